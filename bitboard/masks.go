@@ -42,6 +42,12 @@ var (
 	AdjacentMask [64]uint64
 	// [White, Black]
 	PassedMask [2][64]uint64
+
+	// Attack masks, sliders are occupancy dependent and computed by dragon.
+	KnightMask [64]uint64
+	KingMask   [64]uint64
+	// Squares attacked by a pawn. [White, Black]
+	PawnAttackMask [2][64]uint64
 )
 
 func init() {
@@ -57,6 +63,17 @@ func init() {
 
 		down := DownFill(1<<sq) & ^ranks[rank]
 		PassedMask[1][sq] = Left(down) | down | Right(down)
+
+		b := uint64(1) << sq
+		l1, r1 := Left(b), Right(b)
+		l2, r2 := Left(l1), Right(r1)
+		KnightMask[sq] = Up(Up(l1|r1)) | Down(Down(l1|r1)) | Up(l2|r2) | Down(l2|r2)
+
+		row := l1 | b | r1
+		KingMask[sq] = (Up(row) | row | Down(row)) & ^b
+
+		PawnAttackMask[0][sq] = UpLeft(b) | UpRight(b)
+		PawnAttackMask[1][sq] = DownLeft(b) | DownRight(b)
 	}
 
 	// diagnose(42)
@@ -0,0 +1,193 @@
+// Measure the Elo gaps between skill levels with self-play matches.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noahklein/chess/engine"
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+var (
+	levels  = flag.String("levels", "0,5,10,15,20", "comma-separated skill levels, neighbours play a match")
+	games   = flag.Int("games", 40, "games per match, each opening is played with both colors")
+	nodes   = flag.Int("nodes", 100_000, "node limit per move, on top of the skill limits")
+	plies   = flag.Int("plies", 400, "game length limit in plies, the game is drawn after")
+	verbose = flag.Int("v", -1, "log level, -1 to disable logging")
+)
+
+// Short, balanced openings, so the games don't all start the same way.
+var openings = [][]string{
+	{"e2e4", "e7e5", "g1f3", "b8c6"},
+	{"e2e4", "c7c5", "g1f3", "d7d6"},
+	{"e2e4", "e7e6", "d2d4", "d7d5"},
+	{"e2e4", "c7c6", "d2d4", "d7d5"},
+	{"d2d4", "d7d5", "c2c4", "e7e6"},
+	{"d2d4", "g8f6", "c2c4", "g7g6"},
+	{"d2d4", "g8f6", "c2c4", "e7e6"},
+	{"c2c4", "e7e5", "b1c3", "g8f6"},
+	{"g1f3", "d7d5", "g2g3", "g8f6"},
+	{"e2e4", "d7d6", "d2d4", "g8f6"},
+}
+
+// Adjudication, the same as scripts/gauntlet.sh: a side resigns after 3 moves at -500 or
+// worse, and the game is drawn after move 50 once both sides score it within 20 of even
+// for 5 moves.
+const (
+	resignMoves, resignScore = 3, 500
+	drawMoveNo, drawMoves    = 50, 5
+	drawScore                = 20
+)
+
+func main() {
+	flag.Parse()
+
+	var lvls []int
+	for _, l := range strings.Split(*levels, ",") {
+		lvl, err := strconv.Atoi(l)
+		if err != nil {
+			panic(err)
+		}
+		lvls = append(lvls, lvl)
+	}
+
+	start := time.Now()
+	fmt.Printf("%v games per match, %v nodes per move at most\n", *games, *nodes)
+
+	// Ratings relative to the first level.
+	rating := 0.0
+	fmt.Printf("%5v %5v %12v %6v %14v %6v\n", "level", "vs", "+W =D -L", "score", "elo", "rating")
+	fmt.Printf("%5v %5v %12v %6v %14v %6.0f\n", lvls[0], "", "", "", "", rating)
+	for i := 1; i < len(lvls); i++ {
+		w, d, l := match(lvls[i], lvls[i-1])
+		diff, margin, bound := eloDiff(w, d, l)
+		rating += diff
+
+		n := float64(w + d + l)
+		wdl := fmt.Sprintf("+%v =%v -%v", w, d, l)
+		gap := fmt.Sprintf("%.0f ±%.0f", diff, margin)
+		if bound {
+			gap = fmt.Sprintf(">= %.0f", diff)
+			if diff < 0 {
+				gap = fmt.Sprintf("<= %.0f", diff)
+			}
+		}
+		fmt.Printf("%5v %5v %12v %6.3f %14v %6.0f\n",
+			lvls[i], lvls[i-1], wdl, (float64(w)+float64(d)/2)/n, gap, rating)
+	}
+	fmt.Println("Elapsed:", time.Since(start))
+}
+
+// match plays a's games against b, returning a's wins, draws, and losses.
+func match(a, b int) (w, d, l int) {
+	for g := 0; g < *games; g++ {
+		opening := openings[(g/2)%len(openings)]
+		aWhite := g%2 == 0
+
+		white, black := a, b
+		if !aWhite {
+			white, black = b, a
+		}
+		score, moves := play(white, black, opening)
+		log.Yellow("%v vs %v: %v after %v plies", white, black, score, len(moves))
+
+		if !aWhite {
+			score = 1 - score
+		}
+		switch score {
+		case 1:
+			w++
+		case 0:
+			l++
+		default:
+			d++
+		}
+	}
+	return w, d, l
+}
+
+// play returns white's score, 1 for a win, 0.5 for a draw, and 0 for a loss.
+func play(white, black int, opening []string) (float64, []string) {
+	players := [2]*engine.Engine{player(white), player(black)}
+	moves := append([]string{}, opening...)
+
+	var losing, even [2]int
+	for len(moves) < *plies {
+		side := len(moves) % 2
+		e := players[side]
+		e.Position(dragon.Startpos, moves)
+
+		// The side to move's score from white's point of view.
+		lost := 1.0
+		if side == 0 {
+			lost = 0
+		}
+		legal, inCheck := e.GenMoves()
+		switch {
+		case len(legal) == 0 && inCheck:
+			return lost, moves
+		case len(legal) == 0 || e.Draw():
+			return 0.5, moves
+		}
+
+		result := e.Go(uci.SearchParams{Nodes: *nodes})
+		moves = append(moves, result.Move)
+
+		score := int(result.Score)
+		if result.Mate != engine.NotMate {
+			score = int(math.Copysign(math.MaxInt16, float64(result.Mate)))
+		}
+		losing[side]++
+		if score > -resignScore {
+			losing[side] = 0
+		}
+		even[side]++
+		if score < -drawScore || score > drawScore {
+			even[side] = 0
+		}
+
+		if losing[side] >= resignMoves {
+			return lost, moves
+		}
+		if len(moves)/2 >= drawMoveNo && even[0] >= drawMoves && even[1] >= drawMoves {
+			return 0.5, moves
+		}
+	}
+	return 0.5, moves
+}
+
+func player(level int) *engine.Engine {
+	var e engine.Engine
+	e.NewGame()
+	e.Level = log.Level(*verbose)
+	e.SetOption("Hash", "16")
+	e.SetOption("Skill Level", strconv.Itoa(level))
+	return &e
+}
+
+// eloDiff is the Elo difference implied by a match score, with a 95% margin of error.
+// A perfect score only bounds it, it's counted as half a game short and reported as
+// the least difference.
+func eloDiff(w, d, l int) (diff, margin float64, bound bool) {
+	n := float64(w + d + l)
+	s := (float64(w) + float64(d)/2) / n
+	if w+d == 0 || l+d == 0 {
+		return elo(math.Max(0.5/n, math.Min(1-0.5/n, s))), 0, true
+	}
+
+	variance := (float64(w)*(1-s)*(1-s) + float64(d)*(0.5-s)*(0.5-s) + float64(l)*s*s) / n
+	dev := 1.96 * math.Sqrt(variance/n)
+	lo, hi := elo(math.Max(0.5/n, s-dev)), elo(math.Min(1-0.5/n, s+dev))
+	return elo(s), (hi - lo) / 2, false
+}
+
+func elo(score float64) float64 {
+	return -400 * math.Log10(1/score-1)
+}
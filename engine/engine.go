@@ -2,6 +2,8 @@ package engine
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/noahklein/chess/log"
@@ -14,8 +16,10 @@ const (
 	author  = "Noah Klein"
 	version = "2.0"
 
-	defaultDepth     = 30
-	defualtThinkTime = 5 * time.Second
+	defaultDepth = 30
+
+	// Nodes between polls of the search context, see stopped().
+	pollInterval = 2048
 )
 
 // The chess engine. Must call NewGame() to initialize, followed by Position().
@@ -24,18 +28,62 @@ type Engine struct {
 	board *dragon.Board
 
 	ply            int16
+	rootPly        int16 // Ply at the start of the search.
+	rootWhite      bool  // White is to move at the root, draws are scored from its side.
 	transpositions *Transpositions
 	killer         *Killer
+	pv             *PVTable
 	history        *History
+	moveHistory    *MoveHistory
 	squares        *Squares
 
+	stack     [maxHeight]played      // Moves played at each height of the search.
+	evals     [maxHeight]int16       // Static eval at each height, noEval when in check.
+	excluded  [maxHeight]dragon.Move // Move skipped by a singular extension search.
+	doubleExt [maxHeight + 1]int     // Double extensions on the line to each height.
+	rootDepth int                    // Depth of the current iteration, extensions are capped by it.
+	// Verifying a null move fail high, null moves are off for the rest of the line.
+	nullVerify bool
+
 	hashSizeMB      int // Space in MB allocated for transposition table.
-	threads         int
+	threads         int // Number of Lazy SMP search threads, including the main thread.
+	multiPV         int // Number of best lines to report.
 	disableNullMove bool
+	disableIID      bool    // Internal iterative deepening.
+	contempt        int16   // Centipawns the root side loses by drawing, negative to seek draws.
+	analyseMode     bool    // UCI_AnalyseMode, draws are always scored 0.
+	tune            *Tuning // Search parameters, shared by all threads.
+	skill           *Skill  // Strength limit, only used by the main thread.
+	// Reproducible search: single-threaded and the clock runs on nodes, see timeManager.
+	deterministic bool
+	trace         *Tracer // Records the search tree, nil when off. Main thread only.
+	info          infoReporter
+
+	rootMoves      []dragon.Move // Legal moves at the root.
+	rootRestricted bool          // Root moves are restricted by searchmoves.
+	excludedRoot   []dragon.Move // Root moves already reported on a better MultiPV line.
+
+	id      int       // Thread id, 0 is the main thread.
+	helpers []*Engine // Lazy SMP helper threads, only set on the main thread.
+	stop    bool      // Cached cancellation, see stopped().
+	polled  int64     // Node count at the last poll.
+
+	nodeLimit int64 // This thread's share of the go nodes limit, 0 for no limit.
 
 	nodeCount NodeCount
 	debug     bool // Enables logs/metrics.
+	ponder    bool // Ponder option, the GUI may ask us to think on the opponent's time.
+
+	moveOverhead    time.Duration // Time lost to communicating with the GUI on each move.
+	moveOverheadSet bool          // Set by the option, 0 is a valid overhead.
+
+	// Search control, used by the UCI loop during a search.
+	mu        sync.Mutex
 	cancel    func()
+	searching bool         // A go, solve or trace search is running.
+	time      *timeManager // Only set during Go, nil when IterDeep is called directly.
+	pondering bool
+	ponderEnd chan struct{} // Closed on ponderhit or stop.
 }
 
 func (e *Engine) About() (string, string, string) {
@@ -46,33 +94,62 @@ func (e *Engine) NewGame() {
 	if e.hashSizeMB == 0 {
 		e.hashSizeMB = 64
 	}
+	if e.threads == 0 {
+		e.threads = 1
+	}
+	if e.multiPV == 0 {
+		e.multiPV = 1
+	}
+	if !e.moveOverheadSet {
+		e.moveOverhead = defaultMoveOverhead
+	}
+	if e.tune == nil {
+		tune := defaultTuning
+		e.tune = &tune
+	}
+	if e.skill == nil {
+		e.skill = NewSkill()
+	}
 
 	board := dragon.ParseFen(dragon.Startpos)
 	e.killer = NewKiller()
+	e.pv = &PVTable{}
 	e.board = &board
 	e.transpositions = NewTranspositionTable(uint64(e.hashSizeMB))
 	e.nodeCount = NodeCount{}
 	e.history = &History{}
+	e.moveHistory = &MoveHistory{}
 	e.squares = NewSquares(&board)
 	e.ply = 1
 	e.cancel = func() {}
 	e.debug = true
 }
 
-// Copy an engine for concurrent search.
+// Copy an engine for concurrent search. Only the transposition table is shared, all
+// other search state is owned by the copy.
 func (e *Engine) Copy() *Engine {
 	board := *e.board
 
 	return &Engine{
-		killer:         e.killer,
+		killer:         NewKiller(),
+		pv:             &PVTable{},
 		transpositions: e.transpositions,
 		board:          &board,
 		history:        e.history.Copy(),
+		moveHistory:    e.moveHistory.Copy(),
 		squares:        NewSquares(&board),
 		ply:            e.ply,
-		cancel:         e.cancel,
+		rootPly:        e.rootPly,
+		rootWhite:      e.rootWhite,
 		debug:          e.debug,
 		Logger:         e.Logger,
+
+		disableNullMove: e.disableNullMove,
+		disableIID:      e.disableIID,
+		contempt:        e.contempt,
+		analyseMode:     e.analyseMode,
+		tune:            e.tune,
+		skill:           e.skill,
 	}
 }
 
@@ -85,8 +162,6 @@ func (e *Engine) Position(fen string, moves []string) {
 	}
 	e.squares = NewSquares(&board)
 
-	e.transpositions.hits = 0
-
 	for _, move := range moves {
 		m, err := dragon.ParseMove(move)
 		if err != nil {
@@ -96,42 +171,106 @@ func (e *Engine) Position(fen string, moves []string) {
 	}
 }
 
-// Go is the search entry-point, called by the UCI go command.
+// Go is the search entry-point, called by the UCI go command. A ponder search thinks
+// until ponderhit, when the normal time budget starts, or stop.
 func (e *Engine) Go(params uci.SearchParams) uci.SearchResults {
-	thinkTime := e.thinkTime(params)
-	if params.Infinite {
-		thinkTime = 1 * time.Hour
+	moves, _ := e.GenMoves()
+	if len(moves) == 0 {
+		e.Error("Search() called on game that has already ended.")
+		return uci.SearchResults{}
 	}
 
-	e.Warn("Thinking for %v", thinkTime.String())
+	if params.Start.IsZero() {
+		params.Start = time.Now()
+	}
+	tm := newTimeManager(params, e.board.Wtomove, e.moveOverhead, e.ponder)
+	tm.singleMove = len(moves) == 1
+	if e.deterministic {
+		tm.nodes = e.nodes
+	}
 
-	// TODO: Smarter time management; look at remaining clock.
-	ctx, cancel := context.WithTimeout(context.Background(), thinkTime)
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+
+	e.mu.Lock()
 	e.cancel = cancel
+	e.searching = true
+	e.time = tm
+	e.pondering = params.Ponder
+	e.ponderEnd = make(chan struct{})
+	ponderEnd := e.ponderEnd
+	if hard, ok := tm.HardLimit(); ok {
+		e.Warn("Thinking for %v, at most %v", tm.soft, hard)
+		if e.deterministic {
+			// The hard limit is a node limit.
+			if nodes := clockNodes(hard); params.Nodes == 0 || nodes < params.Nodes {
+				params.Nodes = nodes
+			}
+		} else {
+			timer := time.AfterFunc(hard, cancel)
+			defer timer.Stop()
+		}
+	}
+	e.mu.Unlock()
 
 	if params.Depth == 0 {
 		params.Depth = defaultDepth
 	}
-	if params.Infinite {
+	if params.Infinite || params.Ponder {
 		params.Depth = 100
 	}
-	e.nodeCount.Reset()
 
-	moves, _ := e.GenMoves()
-	if len(moves) == 0 {
-		e.Error("Search() called on game that has already ended.")
-		return uci.SearchResults{}
-	}
+	e.transpositions.NewSearch()
+	e.moveHistory.Age()
 
 	e.UCI("info string null move disabled: %v", e.disableNullMove)
-	e.UCI("info string Hash: %v, %v", e.hashSizeMB, len(e.transpositions.table))
+	e.UCI("info string IID disabled: %v", e.disableIID)
+	e.UCI("info string Hash: %v, %v entries", e.hashSizeMB, len(e.transpositions.table)*bucketLen)
+	e.UCI("info string Threads: %v", e.threads)
+	e.UCI("info string Deterministic: %v", e.deterministic)
+
+	result := e.IterDeep(ctx, params)
+	e.UCI("info string TT hits: %v", e.tableHits())
+
+	// The GUI doesn't expect a bestmove while we're pondering, even if the search is
+	// finished.
+	if params.Ponder {
+		<-ponderEnd
+	}
+
+	e.mu.Lock()
+	e.time = nil
+	e.searching = false
+	e.mu.Unlock()
+	return result
+}
+
+// startSearch registers a debug command's search so Stop can cancel it. Reports false
+// if another search is running.
+func (e *Engine) startSearch(cancel func()) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.searching {
+		return false
+	}
+	e.searching = true
+	e.cancel = cancel
+	return true
+}
 
-	return e.IterDeep(ctx, params)
+func (e *Engine) endSearch() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.searching = false
 }
 
 // Make a move on the board. Returns an unmove callback.
 func (e *Engine) Move(m dragon.Move) func() {
+	if h := e.height(); h >= 0 && h < maxHeight {
+		piece, _ := dragon.GetPieceType(m.From(), e.board)
+		e.stack[h] = played{move: m, piece: piece, capture: dragon.IsCapture(m, e.board)}
+	}
+
 	unapply := e.board.Apply(m)
 	unmoveSquares := e.squares.Move(m)
 	e.history.Push(e.board.Hash())
@@ -146,36 +285,178 @@ func (e *Engine) Move(m dragon.Move) func() {
 	}
 }
 
-// Draw checks for threefold repetitions.
+// nullMove passes the turn. Returns an undo callback.
+func (e *Engine) nullMove() func() {
+	if h := e.height(); h >= 0 && h < maxHeight {
+		e.stack[h] = played{}
+	}
+
+	undo := e.board.NullMove()
+	e.history.Push(e.board.Hash())
+	e.ply++
+
+	return func() {
+		undo()
+		e.ply--
+		e.history.Pop()
+	}
+}
+
+// afterNullMove reports whether the previous move was a null move.
+func (e *Engine) afterNullMove() bool {
+	h := e.height()
+	return h > 0 && h <= maxHeight && e.stack[h-1] == played{}
+}
+
+// height is the number of plies from the root of the search.
+func (e *Engine) height() int16 {
+	return e.ply - e.rootPly
+}
+
+// The moves that led to this position, 1 and 2 plies ago.
+func (e *Engine) prevMoves() [2]played {
+	var prev [2]played
+	for i := range prev {
+		if h := e.height() - 1 - int16(i); h >= 0 && h < maxHeight {
+			prev[i] = e.stack[h]
+		}
+	}
+	return prev
+}
+
+// color of the side to move, 0 for white and 1 for black.
+func (e *Engine) color() int {
+	if e.board.Wtomove {
+		return 0
+	}
+	return 1
+}
+
+// Draw checks for threefold repetitions and the fifty-move rule.
 func (e *Engine) Draw() bool {
-	return e.history.Draw(e.board.Hash(), e.ply, e.board.Halfmoveclock)
+	return e.history.Draw(e.board.Hash(), e.board.Halfmoveclock)
+}
+
+// drawScore is the value of a draw for the side to move. With contempt the root side
+// avoids draws and expects its opponent to seek them, analysis is always unbiased.
+func (e *Engine) drawScore() int16 {
+	contempt := e.activeContempt()
+	if e.board.Wtomove != e.rootWhite {
+		return drawVal + contempt
+	}
+	return drawVal - contempt
+}
+
+func (e *Engine) activeContempt() int16 {
+	if e.analyseMode {
+		return 0
+	}
+	return e.contempt
+}
+
+// ttKey is the position's transposition table key. Scores depend on contempt through
+// the draws in their subtree, so with contempt the key also depends on the contempt and
+// the root side: entries stored while playing one side are never used for the other.
+func (e *Engine) ttKey() uint64 {
+	key := e.board.Hash()
+	contempt := e.activeContempt()
+	if contempt == 0 {
+		return key
+	}
+
+	salt := uint64(uint16(contempt))
+	if e.rootWhite {
+		salt |= 1 << 16
+	}
+	// Multiply to spread the salt over the index and check bits.
+	return key ^ salt*0x9e3779b97f4a7c15
 }
 
 func (e *Engine) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.endPonder()
 	e.cancel()
 }
 
-// IsReady should block until the engine is ready to search.
-func (e *Engine) IsReady() {}
+// PonderHit is called when the opponent played the move we were pondering on. The
+// search continues with the normal time budget.
+func (e *Engine) PonderHit() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-func (e *Engine) ClearTT() {
-	e.transpositions = NewTranspositionTable(uint64(e.hashSizeMB))
+	if !e.pondering {
+		return
+	}
+	e.endPonder()
+
+	e.time.PonderHit()
+	// Without a wall clock the search stops at the soft limit, after an iteration.
+	if hard, ok := e.time.HardLimit(); ok && !e.deterministic {
+		e.Warn("Ponderhit, thinking for at most %v", hard)
+		time.AfterFunc(hard, e.cancel)
+	}
 }
 
-func (e *Engine) thinkTime(params uci.SearchParams) time.Duration {
-	t, inc := params.BlackTime, params.BlackInc
-	if e.board.Wtomove {
-		t, inc = params.WhiteTime, params.WhiteInc
+// Must hold e.mu.
+func (e *Engine) endPonder() {
+	if e.pondering {
+		e.pondering = false
+		close(e.ponderEnd)
+	}
+}
+
+// stopped reports whether the search was cancelled or this thread reached its node
+// limit. ctx.Err() takes a lock shared by every search thread, so it's only polled
+// every pollInterval nodes. Quiescence nodes count too, but don't poll.
+func (e *Engine) stopped(ctx context.Context) bool {
+	if e.stop {
+		return true
 	}
 
-	if t == 0 {
-		return defualtThinkTime
+	nodes := atomic.LoadInt64(&e.nodeCount.nodes)
+	if e.nodeLimit > 0 && nodes >= e.nodeLimit {
+		e.stop = true
+	} else if nodes-e.polled >= pollInterval {
+		e.polled = nodes
+		e.stop = ctx.Err() != nil
+		if e.id == 0 {
+			e.reportStatus()
+		}
 	}
+	return e.stop
+}
 
-	if params.MovesToGo == 0 {
-		params.MovesToGo = 30
+// done is stopped() without the polling interval, for checks outside of the tree.
+func (e *Engine) done(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		e.stop = true
 	}
+	return e.stopped(ctx)
+}
 
-	mtg := time.Duration(params.MovesToGo) + 2
-	return (t + inc*mtg) / mtg
+// Nodes searched by all threads.
+func (e *Engine) nodes() int {
+	n := e.nodeCount.Nodes()
+	for _, h := range e.helpers {
+		n += h.nodeCount.Nodes()
+	}
+	return n
+}
+
+// Transposition table hits across all threads.
+func (e *Engine) tableHits() int {
+	n := e.nodeCount.TableHits()
+	for _, h := range e.helpers {
+		n += h.nodeCount.TableHits()
+	}
+	return n
+}
+
+// IsReady should block until the engine is ready to search.
+func (e *Engine) IsReady() {}
+
+func (e *Engine) ClearTT() {
+	e.transpositions = NewTranspositionTable(uint64(e.hashSizeMB))
 }
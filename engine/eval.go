@@ -145,27 +145,18 @@ func pieceEval(b *dragon.Bitboards) int16 {
 	return int16(score)
 }
 
-func badCapture(attacker, victim int16) bool {
-	// Pawn captures don't lose material.
-	if attacker == dragon.Pawn {
-		return false
-	}
-
-	attVal, vicVal := PieceValue[attacker], PieceValue[victim]
-	return vicVal < attVal-50
-}
-
 const (
 	maxMate = 400
 	NotMate = 500
 )
 
-// Converts an eval score into ply till mate. Returns NotMate if not mating.
+// Converts an eval score into moves till mate, e.g. mate in 2 is 3 plies. Negative if
+// getting mated. Returns NotMate if not mating.
 func mateScore(score int16, ply int16) int16 {
 	var mate int16 = NotMate
 	plyTillMate := -mateVal - abs(score) - ply
 	if plyTillMate < maxMate {
-		mate = plyTillMate / 2
+		mate = (plyTillMate + 1) / 2
 
 		if score < 0 {
 			mate = -mate
@@ -76,9 +76,11 @@ func TestMateScore(t *testing.T) {
 		score, ply, want int16
 	}{
 		{-(mateVal + 40), 30, 5},
-		{-(mateVal + 41), 30, 5},
-		{(mateVal + 20), 15, -2},
+		{-(mateVal + 41), 30, 6},
+		{-(mateVal + 31), 30, 1},
+		{(mateVal + 20), 15, -3},
 		{(mateVal + 20), 16, -2},
+		{(mateVal + 32), 30, -1},
 	}
 
 	for _, tt := range tests {
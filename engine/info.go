@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+// Info lines between completed iterations: fail highs and lows, the root move being
+// searched and progress. Short searches only need the PV, so these start after
+// infoDelay, then come at most every infoInterval so a long go infinite doesn't flood
+// the GUI. Completed iterations are always reported.
+const (
+	infoDelay    = time.Second
+	infoInterval = 500 * time.Millisecond
+)
+
+// infoReporter throttles the main thread's info lines. Reported times count from the go
+// command, nothing is reported outside of IterDeep.
+type infoReporter struct {
+	start time.Time // Zero when not searching.
+	last  time.Time // Last throttled line.
+	// Last currmove. They're throttled on their own so progress lines don't hide which
+	// move is being searched.
+	lastMove time.Time
+}
+
+// due reports whether a throttled line can be sent now, and if so counts it as sent.
+func (ir *infoReporter) due() bool {
+	return ir.throttle(&ir.last)
+}
+
+func (ir *infoReporter) moveDue() bool {
+	if !ir.throttle(&ir.lastMove) {
+		return false
+	}
+	ir.last = ir.lastMove
+	return true
+}
+
+func (ir *infoReporter) throttle(last *time.Time) bool {
+	if ir.start.IsZero() {
+		return false
+	}
+	now := time.Now()
+	if now.Sub(ir.start) < infoDelay || now.Sub(*last) < infoInterval {
+		return false
+	}
+	*last = now
+	return true
+}
+
+// reportCurrMove tells the GUI which root move is being searched.
+func (e *Engine) reportCurrMove(depth int16, move dragon.Move, number int) {
+	if e.id == 0 && e.info.moveDue() {
+		e.UCI(uci.CurrMove(int(depth), move.String(), number))
+	}
+}
+
+// reportStatus sends the node count and hash usage while a root move takes a while.
+// Called from the node polling in stopped().
+func (e *Engine) reportStatus() {
+	if e.info.due() {
+		progress := uci.SearchResults{Nodes: e.nodes(), Hashfull: e.transpositions.PermillFull()}
+		e.UCI(progress.Status(e.info.start))
+	}
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+func TestInfoReporter(t *testing.T) {
+	var idle infoReporter
+	if idle.due() || idle.moveDue() {
+		t.Error("Reported outside of a search")
+	}
+
+	fresh := infoReporter{start: time.Now()}
+	if fresh.due() || fresh.moveDue() {
+		t.Errorf("Reported before the %v delay", infoDelay)
+	}
+
+	ir := infoReporter{start: time.Now().Add(-2 * infoDelay)}
+	if !ir.due() {
+		t.Fatal("Progress not reported after the delay")
+	}
+	if ir.due() {
+		t.Errorf("Progress reported twice within %v", infoInterval)
+	}
+	if !ir.moveDue() {
+		t.Error("currmove throttled by a progress line")
+	}
+	if ir.moveDue() {
+		t.Errorf("currmove reported twice within %v", infoInterval)
+	}
+
+	ir.last = ir.last.Add(-infoInterval)
+	ir.lastMove = ir.lastMove.Add(-infoInterval)
+	if !ir.moveDue() {
+		t.Fatal("currmove not reported after the interval")
+	}
+	if ir.due() {
+		t.Error("Progress reported right after a currmove")
+	}
+}
+
+func TestInfoMultiPV(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	result := e.IterDeep(context.Background(), uci.SearchParams{Depth: 3})
+	if result.MultiPV != 1 {
+		t.Errorf("Best line has multipv %v, want 1", result.MultiPV)
+	}
+	if result.TableHits != 0 {
+		t.Errorf("Reported %v tablebase hits without tablebases", result.TableHits)
+	}
+	if !e.info.start.IsZero() {
+		t.Error("Info reporter still running after the search")
+	}
+}
+
+// Fail highs and lows are reported in searches shorter than infoDelay.
+func TestInfoBounds(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, []string{"e2e4"})
+	e.Debug(false)
+	e.Level = log.UCI
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+
+	e.IterDeep(context.Background(), uci.SearchParams{Depth: 5})
+	w.Close()
+	os.Stdout = stdout
+	info := <-out
+
+	if !strings.Contains(info, "lowerbound") && !strings.Contains(info, "upperbound") {
+		t.Errorf("No bounds reported:\n%v", info)
+	}
+	if strings.Contains(info, "currmove") {
+		t.Errorf("currmove reported before %v:\n%v", infoDelay, info)
+	}
+}
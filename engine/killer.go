@@ -28,6 +28,7 @@ func (k *Killer) Add(ply int16, move dragon.Move) {
 	}
 	// Push new move to front.
 	kms[0], kms[1] = move, kms[0]
+	k.moves[ply] = kms
 }
 
 // Returns 0000 if empty which translates to a1a1, an impossible move.
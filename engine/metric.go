@@ -1,26 +1,36 @@
 package engine
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/noahklein/chess/log"
 )
 
-// NodeCount tracks useful stats for reporting. Not thread-safe.
+// NodeCount tracks useful stats for reporting. Only the owning search thread may
+// write to it, other threads can safely read the node count with Nodes().
 type NodeCount struct {
-	nodes, qNodes int
+	nodes, qNodes int64
+	tableHits     int64
 	maxPly        int16 // For reporting max depth.
 
 	legalKiller int
 }
 
-func (nc *NodeCount) Inc()          { nc.nodes++ }
-func (nc *NodeCount) Qinc()         { nc.qNodes++; nc.nodes++ }
+func (nc *NodeCount) Inc()          { atomic.AddInt64(&nc.nodes, 1) }
+func (nc *NodeCount) Qinc()         { nc.qNodes++; atomic.AddInt64(&nc.nodes, 1) }
 func (nc *NodeCount) Ply(ply int16) { nc.maxPly = max(ply, nc.maxPly) }
+func (nc *NodeCount) Nodes() int    { return int(atomic.LoadInt64(&nc.nodes)) }
+
+func (nc *NodeCount) TableHit()      { atomic.AddInt64(&nc.tableHits, 1) }
+func (nc *NodeCount) TableHits() int { return int(atomic.LoadInt64(&nc.tableHits)) }
 
 func (nc *NodeCount) Reset() {
-	nc.nodes = 0
+	atomic.StoreInt64(&nc.nodes, 0)
+	atomic.StoreInt64(&nc.tableHits, 0)
 	nc.qNodes = 0
 	nc.maxPly = 0
 }
@@ -33,6 +43,27 @@ func (e *Engine) SetOption(option string, value string) error {
 		if value == "false" {
 			e.disableNullMove = true
 		}
+	case "iid":
+		e.disableIID = value == "false"
+	case "contempt":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if i < -maxContempt || i > maxContempt {
+			return fmt.Errorf("contempt must be in [%v, %v], got %v", -maxContempt, maxContempt, i)
+		}
+		e.contempt = int16(i)
+		e.UCI("info string Contempt set to %v", e.contempt)
+	case "skill level", "uci_limitstrength", "uci_elo":
+		return e.setSkill(strings.ToLower(option), value)
+	case "deterministic":
+		e.deterministic = value == "true"
+		e.UCI("info string Deterministic set to %v", e.deterministic)
+	case "uci_analysemode":
+		e.analyseMode = value == "true"
+	case "ponder":
+		e.ponder = value == "true"
 	case "clear hash":
 		e.ClearTT()
 	case "hash":
@@ -47,10 +78,36 @@ func (e *Engine) SetOption(option string, value string) error {
 		if err != nil {
 			return err
 		}
+		if i < 1 {
+			return fmt.Errorf("threads must be positive, got %v", i)
+		}
 		e.threads = i
-		e.UCI("info string Threads set set to %v", e.hashSizeMB)
+		e.UCI("info string Threads set to %v", e.threads)
+	case "move overhead":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			return fmt.Errorf("move overhead can't be negative, got %v", i)
+		}
+		e.moveOverhead, e.moveOverheadSet = time.Duration(i)*time.Millisecond, true
+		e.UCI("info string Move Overhead set to %v", e.moveOverhead)
+	case "multipv":
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		if i < 1 {
+			return fmt.Errorf("multipv must be positive, got %v", i)
+		}
+		e.multiPV = i
+		e.UCI("info string MultiPV set to %v", e.multiPV)
 
 	default:
+		if ok, err := e.setTunable(strings.ToLower(option), value); ok {
+			return err
+		}
 		e.Warn("Unsupported option: %v", option)
 	}
 
@@ -59,9 +116,20 @@ func (e *Engine) SetOption(option string, value string) error {
 
 func (e *Engine) PrintOptions() {
 	e.UCI("option name Nullmove type check default true")
+	e.UCI("option name IID type check default true")
+	e.UCI("option name Ponder type check default false")
+	e.UCI("option name Contempt type spin default 0 min %v max %v", -maxContempt, maxContempt)
+	e.UCI("option name UCI_AnalyseMode type check default false")
+	e.UCI("option name Deterministic type check default false")
+	e.UCI("option name Skill Level type spin default %v min 0 max %v", maxSkill, maxSkill)
+	// UCI_LimitStrength and UCI_Elo still work, but aren't advertised until the ratings
+	// are anchored, see eloLevels.
 	e.UCI("option name Clear Hash type button")
 	e.UCI("option name Hash type spin default 128 min 1 max 1024")
-	e.UCI("option name Threads type spin default 2 min 1 max 12")
+	e.UCI("option name Threads type spin default 1 min 1 max 64")
+	e.UCI("option name MultiPV type spin default 1 min 1 max 64")
+	e.UCI("option name Move Overhead type spin default %v min 0 max 5000", defaultMoveOverhead.Milliseconds())
+	e.printTunables()
 }
 
 // Debug enables logging and metric reporting.
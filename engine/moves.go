@@ -4,8 +4,17 @@ import (
 	"github.com/noahklein/dragon"
 )
 
+// Move ordering, from first to last: the PV move, captures and promotions that don't
+// lose material, killers, the countermove, quiet moves by history and finally losing
+// captures.
 const (
-	killerScore = 16
+	pvScore          = 1 << 30
+	goodCaptureScore = 1 << 28
+	killerScore      = 1 << 26
+	counterScore     = 1 << 25
+	badCaptureScore  = -goodCaptureScore
+	// Quiet checks are tried before other quiet moves with a similar history.
+	checkScore = maxHistory
 )
 
 var (
@@ -23,7 +32,7 @@ var (
 
 type moveScore struct {
 	move  dragon.Move
-	score int16
+	score int32
 }
 
 // MoveSort sorts moves using cheap heuristics, e.g. search captures and promotions
@@ -40,24 +49,42 @@ func (e *Engine) newMoveSorter(moves []dragon.Move) *MoveSort {
 
 	pv, pvOk := e.PVMove()
 	kms := e.killer.Get(e.ply)
+	color, prev := e.color(), e.prevMoves()
+	counter := e.moveHistory.Counter(color, prev[0])
 	for i, move := range moves {
 		ms.moveScores[i].move = move
 
 		if pvOk && move == pv {
-			ms.moveScores[i].score = 10000
+			ms.moveScores[i].score = pvScore
 			continue
 		}
-		if isCheck(e.board, move) {
-			ms.moveScores[i].score = 2000
-		}
 
 		attacker, _ := e.squares.PieceType(move.From())
 		victim, _ := e.squares.PieceType(move.To())
-		ms.moveScores[i].score = mvvLvaTable[victim][attacker]
-		ms.moveScores[i].score += promotionScore[move.Promote()]
+		if victim != dragon.Nothing || move.Promote() != dragon.Nothing {
+			score := int32(mvvLvaTable[victim][attacker] + promotionScore[move.Promote()])
+			if SEE(e.board, move) >= 0 {
+				score += goodCaptureScore
+			} else {
+				score += badCaptureScore
+			}
+			ms.moveScores[i].score = score
+			continue
+		}
 
-		if move == kms[0] || move == kms[1] {
-			ms.moveScores[i].score += killerScore
+		switch move {
+		case kms[0]:
+			ms.moveScores[i].score = killerScore + 1
+		case kms[1]:
+			ms.moveScores[i].score = killerScore
+		case counter:
+			ms.moveScores[i].score = counterScore
+		default:
+			piece, _ := dragon.GetPieceType(move.From(), e.board)
+			ms.moveScores[i].score = e.moveHistory.Score(color, prev, played{move: move, piece: piece})
+			if isCheck(e.board, move) {
+				ms.moveScores[i].score += checkScore
+			}
 		}
 	}
 	return &ms
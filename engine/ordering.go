@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"github.com/noahklein/dragon"
+)
+
+const (
+	// History scores stay within [-maxHistory, maxHistory].
+	maxHistory      = 16384
+	maxHistoryBonus = 1200
+
+	// A piece type and target square, the context of a move for continuation history.
+	pieceSquares = 6 * 64
+)
+
+// played is a move made during the search, with the piece that moved.
+type played struct {
+	move    dragon.Move
+	piece   int
+	capture bool
+}
+
+func (p played) ok() bool { return p.piece != dragon.Nothing }
+
+func (p played) index() int { return (p.piece-1)*64 + int(p.move.To()) }
+
+// MoveHistory scores quiet moves by how often they caused beta cutoffs. Each search
+// thread has its own.
+//   - Butterfly history is indexed by the move's squares.
+//   - The countermove is the last quiet move that refuted the opponent's previous move.
+//   - Continuation history is indexed by the moves 1 and 2 plies ago and the move.
+type MoveHistory struct {
+	butterfly    [2][64][64]int16                        // [color][from][to]
+	counter      [2][pieceSquares]dragon.Move            // [color][previous move]
+	continuation [2][2][pieceSquares][pieceSquares]int16 // [ply-1][color][previous move][move]
+}
+
+func (h *MoveHistory) Copy() *MoveHistory {
+	c := *h
+	return &c
+}
+
+// Score a quiet move, prev are the moves 1 and 2 plies ago.
+func (h *MoveHistory) Score(color int, prev [2]played, m played) int32 {
+	score := int32(h.butterfly[color][m.move.From()][m.move.To()])
+	for i, p := range prev {
+		if p.ok() {
+			score += int32(h.continuation[i][color][p.index()][m.index()])
+		}
+	}
+	return score
+}
+
+// Counter gets the countermove to the opponent's previous move.
+func (h *MoveHistory) Counter(color int, prev played) dragon.Move {
+	if !prev.ok() {
+		return 0
+	}
+	return h.counter[color][prev.index()]
+}
+
+// Update is called when a quiet move causes a beta cutoff, the quiet moves tried before
+// it are penalized.
+func (h *MoveHistory) Update(color int, prev [2]played, best played, tried []played, depth int) {
+	bonus := int32(16 * depth * depth)
+	if bonus > maxHistoryBonus {
+		bonus = maxHistoryBonus
+	}
+
+	h.add(color, prev, best, bonus)
+	for _, m := range tried {
+		h.add(color, prev, m, -bonus)
+	}
+
+	if prev[0].ok() {
+		h.counter[color][prev[0].index()] = best.move
+	}
+}
+
+func (h *MoveHistory) add(color int, prev [2]played, m played, bonus int32) {
+	gravity(&h.butterfly[color][m.move.From()][m.move.To()], bonus)
+	for i, p := range prev {
+		if p.ok() {
+			gravity(&h.continuation[i][color][p.index()][m.index()], bonus)
+		}
+	}
+}
+
+// Age the history between searches, old statistics matter less in the new position.
+func (h *MoveHistory) Age() {
+	for c := range h.butterfly {
+		for from := range h.butterfly[c] {
+			for to := range h.butterfly[c][from] {
+				h.butterfly[c][from][to] /= 2
+			}
+		}
+	}
+	for i := range h.continuation {
+		for c := range h.continuation[i] {
+			for prev := range h.continuation[i][c] {
+				for m := range h.continuation[i][c][prev] {
+					h.continuation[i][c][prev][m] /= 2
+				}
+			}
+		}
+	}
+}
+
+// Gravity: the closer an entry is to the limit the less it grows, so entries never
+// saturate and recent cutoffs outweigh old ones.
+func gravity(entry *int16, bonus int32) {
+	v := int32(*entry)
+	v += bonus - v*abs32(bonus)/maxHistory
+	*entry = int16(v)
+}
+
+func abs32(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/noahklein/dragon"
+)
+
+func TestMoveHistory(t *testing.T) {
+	move := func(s string, piece int) played {
+		m, err := dragon.ParseMove(s)
+		if err != nil {
+			t.Fatalf("Could not parse move %v: %v", s, err)
+		}
+		return played{move: m, piece: piece}
+	}
+
+	var (
+		h     MoveHistory
+		prev  = [2]played{move("e7e5", dragon.Pawn), move("e2e4", dragon.Pawn)}
+		best  = move("g1f3", dragon.Knight)
+		tried = move("f1c4", dragon.Bishop)
+	)
+
+	for i := 0; i < 1000; i++ {
+		h.Update(0, prev, best, []played{tried}, 10)
+	}
+
+	if got := h.Score(0, prev, best); got <= 0 || got > 3*maxHistory {
+		t.Errorf("Best move score = %v, want in (0, %v]", got, 3*maxHistory)
+	}
+	if got := h.Score(0, prev, tried); got >= 0 || got < -3*maxHistory {
+		t.Errorf("Tried move score = %v, want in [%v, 0)", got, -3*maxHistory)
+	}
+	if got := h.Score(1, prev, best); got != 0 {
+		t.Errorf("Other color's score = %v, want 0", got)
+	}
+	if got := h.Counter(0, prev[0]); got != best.move {
+		t.Errorf("Countermove = %v, want %v", got, best.move)
+	}
+
+	// Without the previous moves only butterfly history is left.
+	butterfly := h.Score(0, [2]played{}, best)
+	h.Age()
+	if got := h.Score(0, [2]played{}, best); got != butterfly/2 {
+		t.Errorf("Aged score = %v, want %v", got, butterfly/2)
+	}
+}
+
+func TestKiller(t *testing.T) {
+	k := NewKiller()
+	a, b, c := dragon.Move(1), dragon.Move(2), dragon.Move(3)
+
+	k.Add(5, a)
+	k.Add(5, b)
+	k.Add(5, b)
+	k.Add(5, c)
+
+	if got, want := k.Get(5), [2]dragon.Move{c, b}; got != want {
+		t.Errorf("Killers = %v, want %v", got, want)
+	}
+	if got := k.Get(6); got != [2]dragon.Move{} {
+		t.Errorf("Killers at empty ply = %v, want none", got)
+	}
+}
@@ -0,0 +1,36 @@
+package engine
+
+import "github.com/noahklein/dragon"
+
+// Max search height, plies from the root. Deeper nodes return the static eval.
+const maxHeight = 128
+
+// PVTable is a triangular principal variation table, the PV is collected during the
+// search. Row h holds the PV starting at height h; when a move improves alpha at height
+// h, the row becomes that move followed by the child's row. Not thread-safe, each search
+// thread has its own.
+type PVTable struct {
+	moves  [maxHeight][maxHeight]dragon.Move
+	length [maxHeight]int
+}
+
+// Clear the PV at height h, called when entering a node.
+func (pv *PVTable) Clear(h int16) {
+	pv.length[h] = int(h)
+}
+
+// Update the PV at height h with a new best move followed by the child's PV.
+func (pv *PVTable) Update(h int16, move dragon.Move) {
+	pv.moves[h][h] = move
+	pv.length[h] = int(h) + 1
+
+	if h+1 < maxHeight {
+		child := pv.moves[h+1][h+1 : pv.length[h+1]]
+		pv.length[h] += copy(pv.moves[h][h+1:], child)
+	}
+}
+
+// Line gets the PV starting at height h.
+func (pv *PVTable) Line(h int16) []dragon.Move {
+	return pv.moves[h][h:pv.length[h]]
+}
@@ -3,6 +3,7 @@ package engine
 import (
 	"context"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,43 +13,176 @@ import (
 	"github.com/noahklein/dragon"
 )
 
+var mateTests = []struct {
+	name     string
+	fen      string
+	depth    int
+	want     string
+	wantMate int16
+}{
+	{
+		name:  "mate in 2, w",
+		fen:   "r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0",
+		depth: 2,
+		want:  "d5f6", wantMate: 2,
+	},
+	{
+		name:  "mate in 2, b",
+		fen:   "6k1/pp4p1/2p5/2bp4/8/P5Pb/1P3rrP/2BRRN1K b - - 0 1",
+		depth: 2,
+		want:  "g2g1", wantMate: 2,
+	},
+	{
+		name:  "mate in 3, b",
+		fen:   "r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1",
+		depth: 5,
+		want:  "f8c5", wantMate: 3,
+	},
+	{
+		name:  "K+R vs K, mate in 8, w",
+		fen:   "8/8/8/8/4K1k1/4R3/8/8 w - - 0 1",
+		depth: 17,
+		want:  "e4e5", wantMate: 8,
+	},
+	{
+		name:  "B+B vs K, mate in 8, w",
+		fen:   "8/8/3B4/1k1K4/8/8/2B5/8 w - - 10 6",
+		depth: 20,
+		want:  "d6c5", wantMate: 8,
+	},
+}
+
 func TestMate(t *testing.T) {
+	for _, tt := range mateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+			e.Debug(false)
+			// e.Level = log.NONE
+
+			results := e.IterDeep(context.Background(), uci.SearchParams{
+				Depth: tt.depth,
+			})
+
+			t.Log(puzzledb.LichessUrl(tt.fen))
+
+			if results.Move != tt.want {
+				t.Errorf("Could not find mate: got %v, eval = %v ; want %v", results.Move, results.Score, tt.want)
+			}
+
+			if results.Mate != tt.wantMate {
+				t.Errorf("Wrong mate length: got mate = %v, want %v", results.Mate, tt.wantMate)
+			}
+		})
+	}
+}
+
+// Compares node counts with and without forward pruning: reverse futility pruning,
+// razoring, ProbCut, late move, history and SEE pruning.
+func TestPruningNodes(t *testing.T) {
+	noPruning := defaultTuning
+	noPruning.RFPDepth, noPruning.RazorDepth, noPruning.ProbCutDepth = 0, 0, 100
+	noPruning.LMPDepth, noPruning.HistoryPruneDepth, noPruning.SEEPruneDepth = 0, 0, 0
+
+	search := func(fen string, depth int, tune Tuning) (uci.SearchResults, int) {
+		var e Engine
+		e.NewGame()
+		e.Position(fen, nil)
+		e.Debug(false)
+		e.Level = log.NONE
+		e.tune = &tune
+
+		results := e.IterDeep(context.Background(), uci.SearchParams{Depth: depth})
+		return results, e.nodes()
+	}
+
+	var total, totalPruned int
+	for _, tt := range mateTests {
+		depth := tt.depth
+		if depth > 10 {
+			depth = 10
+		}
+
+		results, nodes := search(tt.fen, depth, noPruning)
+		prunedResults, pruned := search(tt.fen, depth, defaultTuning)
+		total += nodes
+		totalPruned += pruned
+
+		t.Logf("%-24v depth %2v: %8v -> %8v nodes (%+.1f%%), %v -> %v",
+			tt.name, depth, nodes, pruned, 100*float64(pruned-nodes)/float64(nodes),
+			results.Move, prunedResults.Move)
+	}
+
+	t.Logf("Total: %v -> %v nodes", total, totalPruned)
+	if totalPruned > total {
+		t.Errorf("Pruning searched more nodes: got %v, want at most %v", totalPruned, total)
+	}
+}
+
+// Nodes at height 2 compare their static eval with the root's, not with one left over
+// from an earlier search.
+func TestImproving(t *testing.T) {
 	tests := []struct {
-		name     string
-		fen      string
-		depth    int
-		want     string
-		wantMate int16
+		name, fen string
+	}{
+		{"quiet", "r1bqk2r/pppp1ppp/2n2n2/2b1p3/2B1P3/2NP1N2/PPP2PPP/R1BQK2R b KQkq - 0 5"},
+		{"in check", "4k3/8/8/8/1b6/8/8/4K2R w K - 0 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+			e.Debug(false)
+			e.Level = log.NONE
+
+			rootEval := noEval
+			if !e.board.OurKingInCheck() {
+				rootEval = Eval(e.board)
+			}
+			e.evals[0] = rootEval - 500 // Stale.
+			e.rootPly, e.rootWhite, e.rootMoves = e.ply, e.board.Wtomove, e.rootMoveList(nil)
+			e.Search(context.Background(), 2, -infinity, infinity)
+
+			if e.evals[0] != rootEval {
+				t.Fatalf("Root static eval = %v, want %v", e.evals[0], rootEval)
+			}
+			for _, eval := range []int16{rootEval - 1, rootEval, rootEval + 1} {
+				want := 0
+				if rootEval != noEval && eval > rootEval {
+					want = 1
+				}
+				e.evals[2] = eval
+				if got := e.improving(2); got != want {
+					t.Errorf("improving(2) with eval %v = %v, want %v", eval, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Null move pruning assumes passing is never the best move, in zugzwang it is.
+func TestZugzwang(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		depth int
+		mate  bool  // The side to move mates.
+		below int16 // Otherwise the score must be below this.
 	}{
 		{
-			name:  "mate in 2, w",
-			fen:   "r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0",
-			depth: 2,
-			want:  "d5f6", wantMate: 1,
-		},
-		{
-			name:  "mate in 2, b",
-			fen:   "6k1/pp4p1/2p5/2bp4/8/P5Pb/1P3rrP/2BRRN1K b - - 0 1",
-			depth: 2,
-			want:  "g2g1", wantMate: 1,
-		},
-		{
-			name:  "mate in 3, b",
-			fen:   "r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1",
-			depth: 5,
-			want:  "f8c5", wantMate: 2,
-		},
-		{
-			name:  "K+R vs K, mate in 8, w",
-			fen:   "8/8/8/8/4K1k1/4R3/8/8 w - - 0 1",
-			depth: 16,
-			want:  "e4e5", wantMate: 6, // TODO: should be 8
+			name:  "K+P vs K, white must let the pawn promote",
+			fen:   "8/8/8/8/8/2k5/2p5/2K5 b - - 0 1",
+			depth: 12,
+			mate:  true,
 		},
 		{
-			name:  "B+B vs K, mate in 8, w",
-			fen:   "8/8/3B4/1k1K4/8/8/2B5/8 w - - 10 6",
-			depth: 20,
-			want:  "d6c5", wantMate: 8,
+			name:  "K+P vs K, drawn with the opposition",
+			fen:   "8/5k2/8/4PK2/8/8/8/8 w - - 0 1",
+			depth: 14,
+			below: rookVal,
 		},
 	}
 
@@ -58,65 +192,516 @@ func TestMate(t *testing.T) {
 			e.NewGame()
 			e.Position(tt.fen, nil)
 			e.Debug(false)
-			// e.Level = log.NONE
+			e.Level = log.NONE
+
+			results := e.IterDeep(context.Background(), uci.SearchParams{Depth: tt.depth})
+			if tt.mate && (results.Mate == NotMate || results.Mate < 0) {
+				t.Errorf("Could not find mate: got %v, eval = %v", results.Move, results.Score)
+			}
+			if !tt.mate && results.Score >= tt.below {
+				t.Errorf("Score = %v, want below %v", results.Score, tt.below)
+			}
+		})
+	}
+}
+
+// Without a TT entry, a PV node searches at reduced depth first and tries the move it
+// finds first.
+func TestIID(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		var e Engine
+		e.NewGame()
+		e.Position("r1bqk2r/pppp1ppp/2n2n2/2b1p3/2B1P3/2NP1N2/PPP2PPP/R1BQK2R b KQkq - 0 5", nil)
+		e.Debug(false)
+		e.Level = log.NONE
+		e.disableIID = disable
+
+		// Root moves are PV nodes at the root depth, the first one is searched with an
+		// empty table.
+		depth := e.tune.IIDDepth
+		e.rootPly, e.rootWhite, e.rootMoves = e.ply, e.board.Wtomove, e.rootMoveList(nil)
+		e.trace = NewTracer(depth, nil)
+		e.Search(context.Background(), int16(depth), -infinity, infinity)
+
+		node := e.trace.Root.Children[0]
+		var iid *TraceEvent
+		for i, ev := range node.Events {
+			if ev.Event == "iid" {
+				iid = &node.Events[i]
+			}
+		}
+		if disable {
+			if iid != nil {
+				t.Errorf("IID ran while disabled: %+v", node.Events)
+			}
+			continue
+		}
+
+		if iid == nil {
+			t.Fatalf("IID didn't run at %v: %+v", node.Move, node.Events)
+		}
+		if iid.Move == "" {
+			t.Fatal("IID left no TT move")
+		}
+		// The reduced search is the first child, without a move. Then the TT move.
+		if len(node.Children) < 2 || node.Children[0].Move != "" || node.Children[1].Move != iid.Move {
+			t.Errorf("IID move %v not searched first", iid.Move)
+		}
+	}
+}
+
+// Compares node counts with and without internal iterative deepening. IID is for PV
+// nodes without a TT entry, so each position is searched once at a fixed depth from an
+// empty table. Under iterative deepening those nodes are rare, and the few IID searches
+// reorder the table and history enough to swing a whole search either way.
+func TestIIDNodes(t *testing.T) {
+	search := func(fen string, depth int, disableIID bool) (uci.SearchResults, int) {
+		var e Engine
+		e.NewGame()
+		e.Position(fen, nil)
+		e.Debug(false)
+		e.Level = log.NONE
+		e.disableIID = disableIID
+
+		e.rootPly, e.rootWhite, e.rootMoves = e.ply, e.board.Wtomove, e.rootMoveList(nil)
+		results := e.Search(context.Background(), int16(depth), -infinity, infinity)
+		return results, e.nodes()
+	}
+
+	tests := []struct {
+		name, fen string
+	}{
+		{"open game", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"queen's gambit declined", "r1bq1rk1/pp2bppp/2n1pn2/3p4/2PP4/2N1PN2/PP3PPP/R2QKB1R w KQ - 0 8"},
+		{"italian", "r1bqk2r/pppp1ppp/2n2n2/2b1p3/2B1P3/2NP1N2/PPP2PPP/R1BQK2R b KQkq - 0 5"},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"},
+		{"rook endgame", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1"},
+	}
+
+	const depth = 8
+	var total, totalIID int
+	for _, tt := range tests {
+		results, nodes := search(tt.fen, depth, true)
+		iidResults, iid := search(tt.fen, depth, false)
+		total += nodes
+		totalIID += iid
+
+		t.Logf("%-24v depth %2v: %8v -> %8v nodes (%+.1f%%), %v -> %v",
+			tt.name, depth, nodes, iid, 100*float64(iid-nodes)/float64(nodes),
+			results.Move, iidResults.Move)
+	}
+
+	t.Logf("Total: %v -> %v nodes", total, totalIID)
+	if totalIID > total {
+		t.Errorf("IID searched more nodes: got %v, want at most %v", totalIID, total)
+	}
+}
+
+func TestLazySMP(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+	if err := e.SetOption("threads", "4"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := e.IterDeep(context.Background(), uci.SearchParams{
+		Depth: 5,
+	})
+
+	if results.Move != "f8c5" {
+		t.Errorf("Could not find mate: got %v, eval = %v ; want f8c5", results.Move, results.Score)
+	}
+	if len(e.helpers) != 3 {
+		t.Fatalf("Wrong number of helpers: got %v, want 3", len(e.helpers))
+	}
+
+	// The mate can be found before the helpers get going, search long enough for each
+	// of them to count some nodes.
+	e.Position(dragon.Startpos, nil)
+	results = e.IterDeep(context.Background(), uci.SearchParams{Depth: 7})
+	total := e.nodeCount.Nodes()
+	for i, helper := range e.helpers {
+		if helper.nodeCount.Nodes() == 0 {
+			t.Errorf("Helper %v searched no nodes", i+1)
+		}
+		total += helper.nodeCount.Nodes()
+	}
+	if results.Nodes != total {
+		t.Errorf("Node count doesn't include helper threads: got %v, want %v", results.Nodes, total)
+	}
+}
+
+// Quiescence nodes are counted but don't poll, the poll must not depend on hitting an
+// exact node count.
+func TestStoppedPolling(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for nodes := 0; nodes < 10*pollInterval; nodes += 3 {
+		e.nodeCount.Qinc()
+		e.nodeCount.Qinc()
+		e.nodeCount.Inc()
+		if e.stopped(ctx) {
+			if n := e.nodeCount.Nodes(); n > pollInterval+3 {
+				t.Errorf("Cancellation noticed after %v nodes, want at most %v", n, pollInterval+3)
+			}
+			return
+		}
+	}
+	t.Errorf("Cancellation not noticed after %v nodes", e.nodeCount.Nodes())
+}
+
+func TestPrincipalVariation(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		depth int
+	}{
+		{"mate in 2, w", "r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0", 2},
+		{"mate in 3, b", "r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+			e.Debug(false)
+			e.Level = log.NONE
 
 			results := e.IterDeep(context.Background(), uci.SearchParams{
 				Depth: tt.depth,
 			})
 
-			t.Log(puzzledb.LichessUrl(tt.fen))
+			// Play out the PV, it should be legal and end in mate.
+			for _, m := range results.PV {
+				move, err := dragon.ParseMove(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !e.legal(move) {
+					t.Fatalf("Illegal move %v in PV %v", m, results.PV)
+				}
+				e.Move(move)
+			}
+			if moves, inCheck := e.GenMoves(); len(moves) > 0 || !inCheck {
+				t.Errorf("PV doesn't end in mate: %v", results.PV)
+			}
+		})
+	}
+}
 
-			if results.Move != tt.want {
-				t.Errorf("Could not find mate: got %v, eval = %v ; want %v", results.Move, results.Score, tt.want)
+func TestMultiPV(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+	if err := e.SetOption("multipv", "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := e.IterDeep(context.Background(), uci.SearchParams{
+		Depth: 3,
+	})
+
+	if len(results.Lines) != 3 {
+		t.Fatalf("Wrong number of lines: got %v, want 3", len(results.Lines))
+	}
+	if results.Move != "d5f6" || results.Lines[0].Move != results.Move {
+		t.Errorf("Best line isn't first: got %v, lines[0] = %v; want d5f6", results.Move, results.Lines[0].Move)
+	}
+
+	seen := map[string]bool{}
+	for i, line := range results.Lines {
+		if line.MultiPV != i+1 {
+			t.Errorf("Wrong multipv rank: got %v, want %v", line.MultiPV, i+1)
+		}
+		if i > 0 && line.Score > results.Lines[i-1].Score {
+			t.Errorf("Lines not sorted: line %v scored %v > %v", i+1, line.Score, results.Lines[i-1].Score)
+		}
+		if seen[line.Move] {
+			t.Errorf("Duplicate line for %v", line.Move)
+		}
+		seen[line.Move] = true
+	}
+}
+
+func TestPonder(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, []string{"e2e4"})
+	e.Debug(false)
+	e.Level = log.NONE
+
+	done := make(chan uci.SearchResults)
+	go func() {
+		done <- e.Go(uci.SearchParams{
+			Ponder:    true,
+			WhiteTime: 3 * time.Second,
+			BlackTime: 3 * time.Second,
+		})
+	}()
+
+	// Normal think time is 100ms, pondering should ignore it.
+	select {
+	case <-done:
+		t.Fatal("Ponder search returned before ponderhit")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	e.PonderHit()
+	select {
+	case result := <-done:
+		if result.Move == "" {
+			t.Error("No best move after ponderhit")
+		}
+	case <-time.After(2 * time.Second):
+		e.Stop()
+		t.Fatal("Ponder search didn't stop after ponderhit")
+	}
+}
+
+func TestSearchMoves(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	// Mate starts with d5f6, but we can only consider other moves.
+	searchMoves := []string{"e1d1", "c4b5", "a1a8", "e5f7"}
+	results := e.IterDeep(context.Background(), uci.SearchParams{
+		Depth:       3,
+		SearchMoves: searchMoves,
+	})
+
+	if results.Move != "c4b5" && results.Move != "e1d1" && results.Move != "e5f7" {
+		t.Errorf("Searched a move outside of searchmoves: got %v, want one of %v", results.Move, searchMoves)
+	}
+	if len(e.rootMoves) != 3 {
+		t.Errorf("Illegal searchmove not skipped: got root moves %v", e.rootMoves)
+	}
+
+	// All illegal, search every move.
+	results = e.IterDeep(context.Background(), uci.SearchParams{
+		Depth:       3,
+		SearchMoves: []string{"a1a8"},
+	})
+	if results.Move != "d5f6" {
+		t.Errorf("Could not find mate: got %v, want d5f6", results.Move)
+	}
+}
+
+func TestNodeLimit(t *testing.T) {
+	const limit = 50000
+
+	search := func() uci.SearchResults {
+		var e Engine
+		e.NewGame()
+		e.Position(dragon.Startpos, []string{"e2e4", "e7e5"})
+		e.Debug(false)
+		e.Level = log.NONE
+
+		return e.IterDeep(context.Background(), uci.SearchParams{
+			Depth: 100,
+			Nodes: limit,
+		})
+	}
+
+	first, second := search(), search()
+	if first.Nodes < limit || first.Nodes > limit+limit/10 {
+		t.Errorf("Node limit not respected: searched %v nodes, want about %v", first.Nodes, limit)
+	}
+	if first.Nodes != second.Nodes || first.Move != second.Move || first.Score != second.Score {
+		t.Errorf("Node limited search not reproducible: got %v %v (%v nodes), then %v %v (%v nodes)",
+			first.Move, first.Score, first.Nodes, second.Move, second.Score, second.Nodes)
+	}
+}
+
+// A timed, multi-threaded go is reproducible in deterministic mode.
+func TestDeterministic(t *testing.T) {
+	params := uci.SearchParams{WhiteTime: 10 * time.Second, BlackTime: 10 * time.Second}
+	hard := newTimeManager(params, true, defaultMoveOverhead, false).hard
+
+	search := func() uci.SearchResults {
+		var e Engine
+		e.NewGame()
+		e.Position(dragon.Startpos, []string{"e2e4", "e7e5"})
+		e.Debug(false)
+		e.Level = log.NONE
+		for option, value := range map[string]string{"threads": "4", "deterministic": "true"} {
+			if err := e.SetOption(option, value); err != nil {
+				t.Fatal(err)
 			}
+		}
+		return e.Go(params)
+	}
 
-			if results.Mate <= 0 {
-				t.Errorf("Engine did not report mate: got mate = %v, want %v", results.Mate, tt.wantMate)
+	first, second := search(), search()
+	if first.Nodes > clockNodes(hard)+clockNodes(hard)/10 {
+		t.Errorf("Searched %v nodes, want at most about %v", first.Nodes, clockNodes(hard))
+	}
+	if first.Nodes != second.Nodes || first.Move != second.Move || first.Score != second.Score ||
+		strings.Join(first.PV, " ") != strings.Join(second.PV, " ") {
+		t.Errorf("Deterministic search not reproducible: got %v %v %v (%v nodes), then %v %v %v (%v nodes)",
+			first.Move, first.Score, first.PV, first.Nodes, second.Move, second.Score, second.PV, second.Nodes)
+	}
+}
+
+func TestGoMate(t *testing.T) {
+	tests := []struct {
+		name     string
+		fen      string
+		mate     int
+		want     string
+		wantMate bool
+	}{
+		{"mate in 2", "r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0", 2, "d5f6", true},
+		{"no mate in 2", dragon.Startpos, 2, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+			e.Debug(false)
+			e.Level = log.NONE
+
+			results := e.IterDeep(context.Background(), uci.SearchParams{
+				Mate: tt.mate,
+			})
+
+			if gotMate := results.Mate != NotMate && results.Mate > 0; gotMate != tt.wantMate {
+				t.Errorf("Wrong mate result: got mate = %v, want mate found = %v", results.Mate, tt.wantMate)
 			}
+			if tt.want != "" && results.Move != tt.want {
+				t.Errorf("Could not find mate: got %v, want %v", results.Move, tt.want)
+			}
+			if results.Depth > 2*tt.mate-1 {
+				t.Errorf("Searched too deep: got depth %v, want at most %v", results.Depth, 2*tt.mate-1)
+			}
+		})
+	}
+}
 
-			// TODO: fix mate search/scores.
-			// if results.Mate != tt.wantMate {
-			// 	t.Errorf("Engine did not report mate: got mate = %v, want %v", results.Mate, tt.wantMate)
-			// }
+func TestSingularExtension(t *testing.T) {
+	tests := []struct {
+		name     string
+		fen      string
+		singular bool
+	}{
+		{"only recapture", "3rk3/8/8/3Q4/8/8/8/4K3 b - - 0 1", true},
+		{"many good moves", dragon.Startpos, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+			e.Debug(false)
+			e.Level = log.NONE
+
+			const depth = 5
+			ctx := context.Background()
+			e.IterDeep(ctx, uci.SearchParams{Depth: depth})
+
+			entry, ok := e.probe()
+			if !ok {
+				t.Fatal("Root position not in transposition table")
+			}
+			ext, _, cut := e.singular(ctx, entry, infinity, depth)
+			if cut {
+				t.Errorf("Multi-cut with an infinite beta")
+			}
+			if got := ext > 0; got != tt.singular {
+				t.Errorf("TT move %v singular = %v, want %v", entry.best, got, tt.singular)
+			}
 		})
 	}
 }
 
 func TestForcedDraw(t *testing.T) {
-	// TODO: fix three-fold detection.
-	t.SkipNow()
+	const fen = "5r1k/8/6Q1/8/1b6/2n5/1q6/7K w - - 0 1" // Black has mate in 2, white to play and draw.
 
 	tests := []struct {
-		fen   string
-		depth int
-		want  string
+		name        string
+		contempt    int16
+		analyseMode bool
+		want        int16
 	}{
-		// Black has mate in 2, white to play and draw.
-		{"5r1k/8/6Q1/8/1b6/2n5/1q6/7K w - - 0 1", 6, "g6h6"},
+		{name: "no contempt", want: 0},
+		{name: "contempt", contempt: 30, want: -30},
+		{name: "negative contempt", contempt: -30, want: 30},
+		{name: "analysis ignores contempt", contempt: 30, analyseMode: true, want: 0},
 	}
 
 	for _, tt := range tests {
-		t.Run(tt.want, func(t *testing.T) {
+		t.Run(tt.name, func(t *testing.T) {
 			var e Engine
 			e.NewGame()
-			e.Position(tt.fen, nil)
+			e.contempt = tt.contempt
+			e.analyseMode = tt.analyseMode
+			e.Position(fen, nil)
 			e.Debug(false)
 
 			results := e.IterDeep(context.Background(), uci.SearchParams{
-				Depth: tt.depth,
+				Depth: 6,
 			})
 
-			if results.Move != tt.want {
-				t.Errorf("Could not find forced draw: got %v, eval = %v ; want %v", results.Move, results.Score, tt.want)
+			if results.Move != "g6h6" {
+				t.Errorf("Could not find forced draw: got %v, eval = %v ; want g6h6", results.Move, results.Score)
 			}
-
-			// TODO: fix draw evaluation.
-			if results.Score != 0 {
-				t.Errorf("Bad draw eval: got %v, want 0", results.Score)
+			if results.Score != tt.want {
+				t.Errorf("Bad draw eval: got %v, want %v", results.Score, tt.want)
 			}
 		})
 	}
+}
+
+func TestContempt(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, nil)
+	e.Debug(false)
+	e.contempt = 20
+	e.rootWhite = true
+
+	if got := e.drawScore(); got != -20 {
+		t.Errorf("Root side's draw score = %v, want -20", got)
+	}
+	white := e.ttKey()
+
+	move, err := dragon.ParseMove("e2e4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmove := e.Move(move)
+	if got := e.drawScore(); got != 20 {
+		t.Errorf("Opponent's draw score = %v, want 20", got)
+	}
+	unmove()
 
+	// Playing the other side, the same position must not share entries.
+	e.rootWhite = false
+	if e.ttKey() == white {
+		t.Error("Table key doesn't depend on the root side")
+	}
+
+	e.analyseMode = true
+	if got := e.drawScore(); got != 0 {
+		t.Errorf("Analysis draw score = %v, want 0", got)
+	}
+	if got, want := e.ttKey(), e.board.Hash(); got != want {
+		t.Errorf("Analysis table key = %v, want the position hash %v", got, want)
+	}
 }
 
 func TestMvvLva(t *testing.T) {
@@ -132,7 +717,6 @@ func TestMvvLva(t *testing.T) {
 		"b5d7", // BxQ
 		"d1d7", // RxQ
 		"e6f7", // PxB
-		"b5a4", // BxP
 	}
 
 	moves, _ := e.board.GenerateLegalMoves()
@@ -149,6 +733,127 @@ func TestMvvLva(t *testing.T) {
 			t.Errorf("Wrong move: got %v, want %v", got.String(), move)
 		}
 	}
+
+	// BxP loses the bishop to Qxa4, losing captures are searched last.
+	for i := len(want); i < len(moves); i++ {
+		moveSorter.Next(i)
+	}
+	if got := moveSorter.moveScores[len(moves)-1].move.String(); got != "b5a4" {
+		t.Errorf("Wrong last move: got %v, want b5a4", got)
+	}
+}
+
+// Fail-soft: scores outside of the window are bounds on the real score, not the window.
+func TestFailSoft(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("4k3/8/8/8/8/8/8/3QK3 w - - 0 1", nil) // Up a queen.
+	e.Debug(false)
+	e.Level = log.NONE
+
+	if got := e.AlphaBeta(context.Background(), -10, 10, 4); got <= 10 {
+		t.Errorf("AlphaBeta() failed high with %v, want a lower bound above beta", got)
+	}
+	if got := e.AlphaBeta(context.Background(), -10, 10, 4); got <= 10 {
+		t.Errorf("AlphaBeta() failed high with %v from the TT, want a lower bound above beta", got)
+	}
+	if got := e.Quiesce(-10, 10, true); got <= 10 {
+		t.Errorf("Quiesce() failed high with %v, want the stand pat score", got)
+	}
+}
+
+func TestAspiration(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("4k3/8/8/8/8/8/8/3QK3 w - - 0 1", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+	e.rootPly = e.ply
+	e.rootMoves, _ = e.GenMoves()
+
+	var reports []uci.SearchResults
+	report := func(result uci.SearchResults) { reports = append(reports, result) }
+
+	// The previous score is far too low, the search must fail high until it gets there.
+	result := e.aspiration(context.Background(), 4, 0, true, report)
+	if len(reports) == 0 {
+		t.Fatal("No fail highs reported")
+	}
+	for _, r := range reports {
+		if !r.LowerBound || r.UpperBound {
+			t.Errorf("Fail high reported as lowerbound = %v, upperbound = %v", r.LowerBound, r.UpperBound)
+		}
+	}
+	if result.LowerBound || result.UpperBound {
+		t.Errorf("Final result is a bound, score %v", result.Score)
+	}
+	if result.Score < queenVal {
+		t.Errorf("Score = %v, want at least %v", result.Score, queenVal)
+	}
+
+	// Fail low, the previous score is far too high.
+	e.pv.Clear(0)
+	reports = nil
+	result = e.aspiration(context.Background(), 4, 3*queenVal, true, report)
+	if len(reports) == 0 || !reports[0].UpperBound {
+		t.Fatalf("No fail low reported: %+v", reports)
+	}
+	if result.Score < queenVal || result.Score >= 3*queenVal {
+		t.Errorf("Score = %v, want in [%v, %v)", result.Score, queenVal, 3*queenVal)
+	}
+}
+
+func TestQuiesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		fen    string
+		checks bool
+		mate   bool
+		want   func(score int16) bool
+	}{
+		{
+			name: "in check, no stand pat",
+			fen:  "q3k3/2N5/8/8/8/8/8/4K3 b - - 0 1",
+			want: func(score int16) bool { return score < 0 },
+		},
+		{
+			name:   "quiet check mates",
+			fen:    "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1",
+			checks: true,
+			mate:   true,
+		},
+		{
+			name: "quiet checks only at the first ply",
+			fen:  "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1",
+		},
+		{
+			name: "checkmated",
+			fen:  "R5k1/5ppp/8/8/8/8/8/6K1 b - - 0 1",
+			mate: true,
+			want: func(score int16) bool { return score < 0 },
+		},
+		{
+			name: "en passant capture",
+			fen:  "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1",
+			want: func(score int16) bool { return score > pawnVal },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e Engine
+			e.NewGame()
+			e.Position(tt.fen, nil)
+
+			score := e.Quiesce(-infinity, infinity, tt.checks)
+			if mate := mateScore(score, e.ply) != NotMate; mate != tt.mate {
+				t.Errorf("Quiesce() = %v, mate = %v; want mate = %v", score, mate, tt.mate)
+			}
+			if tt.want != nil && !tt.want(score) {
+				t.Errorf("Quiesce() = %v, unexpected score", score)
+			}
+		})
+	}
 }
 
 func TestThreefold(t *testing.T) {
@@ -213,3 +918,40 @@ func benchmarkSearch(depth int, b *testing.B) {
 
 	}
 }
+
+func TestReductions(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Debug(false)
+	e.Level = log.NONE
+
+	r := &e.tune.reductions
+	if r[3][1] != 0 {
+		t.Errorf("Early move reduction = %v, want 0", r[3][1])
+	}
+	for d := 2; d < maxReduction; d++ {
+		for m := 2; m < maxReduction; m++ {
+			if r[d][m] < r[d-1][m] || r[d][m] < r[d][m-1] {
+				t.Fatalf("Reductions must grow with depth and move number, got %v at [%v][%v]", r[d][m], d, m)
+			}
+		}
+	}
+
+	deep := r[20][20]
+	if err := e.SetOption("LMR Divisor", "112"); err != nil {
+		t.Fatalf("Could not set LMR Divisor: %v", err)
+	}
+	if r[20][20] <= deep {
+		t.Errorf("Halving LMR Divisor should rebuild the table: got %v, want > %v", r[20][20], deep)
+	}
+	if defaultTuning.reductions[20][20] != deep {
+		t.Errorf("Setting an option changed the default tuning")
+	}
+
+	if got := e.reduction(4, 30, true, true, true, maxHistory, 1); got != 0 {
+		t.Errorf("Reduction of a PV check killer = %v, want 0", got)
+	}
+	if got := e.reduction(4, 60, false, false, false, -maxHistory, 0); got != 2 {
+		t.Errorf("Reduction at depth 4 = %v, want capped at 2", got)
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"github.com/noahklein/chess/bitboard"
+	"github.com/noahklein/dragon"
+)
+
+// SEE (static exchange evaluation) is the material the side to move wins or loses with
+// a move, assuming both sides keep recapturing on the target square with their least
+// valuable attacker and stop once recapturing loses material. Sliders behind the
+// capturing pieces join in (x-rays). Pins and checks are ignored.
+func SEE(board *dragon.Board, move dragon.Move) int16 {
+	from, to := move.From(), move.To()
+	occupied := (board.White.All | board.Black.All) &^ (uint64(1) << from)
+
+	attacker, _ := dragon.GetPieceType(from, board)
+	victim, _ := dragon.GetPieceType(to, board)
+
+	var gain [64]int16
+	gain[0] = PieceValue[victim]
+
+	if attacker == dragon.Pawn && victim == dragon.Nothing && bitboard.File(from) != bitboard.File(to) {
+		// En passant, the captured pawn is next to the pawn, not on the target square.
+		gain[0] = pawnVal
+		occupied &^= uint64(1) << (bitboard.Rank(from)*8 + bitboard.File(to))
+	}
+
+	onSquare := PieceValue[attacker]
+	if promote := move.Promote(); promote != dragon.Nothing {
+		gain[0] += PieceValue[promote] - pawnVal
+		onSquare = PieceValue[promote]
+	}
+
+	diagonal := board.White.Bishops | board.Black.Bishops | board.White.Queens | board.Black.Queens
+	straight := board.White.Rooks | board.Black.Rooks | board.White.Queens | board.Black.Queens
+	attackers := attackersTo(board, to, occupied) & occupied
+
+	white := !board.Wtomove
+	var d int
+	for {
+		d++
+		// Speculative: what the other side gains by recapturing the piece on the square.
+		gain[d] = onSquare - gain[d-1]
+
+		side := board.Black
+		if white {
+			side = board.White
+		}
+		piece, bb := leastValuable(side, attackers)
+		if bb == 0 {
+			break
+		}
+
+		occupied &^= bb
+		// Removing the capturing piece may reveal a slider behind it.
+		attackers |= dragon.CalculateBishopMoveBitboard(to, occupied)&diagonal |
+			dragon.CalculateRookMoveBitboard(to, occupied)&straight
+		attackers &= occupied
+
+		onSquare = PieceValue[piece]
+		white = !white
+	}
+
+	// Either side may stop capturing, minimax back to the root. The last gain is
+	// speculative and dropped.
+	for d--; d > 0; d-- {
+		gain[d-1] = -max(-gain[d-1], gain[d])
+	}
+	return gain[0]
+}
+
+// All pieces of both sides attacking a square, given the occupied squares.
+func attackersTo(board *dragon.Board, sq uint8, occupied uint64) uint64 {
+	w, b := &board.White, &board.Black
+	return bitboard.PawnAttackMask[1][sq]&w.Pawns |
+		bitboard.PawnAttackMask[0][sq]&b.Pawns |
+		bitboard.KnightMask[sq]&(w.Knights|b.Knights) |
+		bitboard.KingMask[sq]&(w.Kings|b.Kings) |
+		dragon.CalculateBishopMoveBitboard(sq, occupied)&(w.Bishops|b.Bishops|w.Queens|b.Queens) |
+		dragon.CalculateRookMoveBitboard(sq, occupied)&(w.Rooks|b.Rooks|w.Queens|b.Queens)
+}
+
+// The least valuable of a side's attackers, and a bitboard of its square.
+func leastValuable(side dragon.Bitboards, attackers uint64) (int, uint64) {
+	pieces := [...]uint64{side.Pawns, side.Knights, side.Bishops, side.Rooks, side.Queens, side.Kings}
+	for i, bb := range pieces {
+		if set := bb & attackers; set != 0 {
+			return dragon.Pawn + i, set & -set
+		}
+	}
+	return dragon.Nothing, 0
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/noahklein/dragon"
+)
+
+func TestSEE(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		move string
+		want int16
+	}{
+		{
+			name: "undefended pawn",
+			fen:  "1k1r4/1pp4p/p7/4p3/8/P5P1/1PP4P/2K1R3 w - - 0 1",
+			move: "e1e5",
+			want: pawnVal,
+		},
+		{
+			name: "pawn takes defended pawn",
+			fen:  "4k3/8/2p5/3p4/4P3/8/8/4K3 w - - 0 1",
+			move: "e4d5",
+			want: 0,
+		},
+		{
+			name: "queen takes pawn defended by pawn",
+			fen:  "4k3/2p5/3p4/8/8/8/3Q4/4K3 w - - 0 1",
+			move: "d2d6",
+			want: pawnVal - queenVal,
+		},
+		{
+			name: "knight takes defended pawn, x-rays on both sides",
+			fen:  "1k1r3q/1ppn3p/p4b2/4p3/8/P2N2P1/1PP1R1BP/2K1Q3 w - - 0 1",
+			move: "d3e5",
+			want: pawnVal - knightVal,
+		},
+		{
+			name: "doubled rooks win a defended rook",
+			fen:  "3rk3/8/8/3r4/8/8/3R4/3RK3 w - - 0 1",
+			move: "d2d5",
+			want: rookVal,
+		},
+		{
+			name: "rook takes rook defended by a rook behind it",
+			fen:  "3rk3/8/8/3r4/8/8/8/3RK3 w - - 0 1",
+			move: "d1d5",
+			want: 0,
+		},
+		{
+			name: "bishop takes knight defended by pawn",
+			fen:  "4k3/8/4p3/3n4/8/1B6/8/4K3 w - - 0 1",
+			move: "b3d5",
+			want: knightVal - bishopVal,
+		},
+		{
+			name: "king can't recapture a defended piece",
+			fen:  "3rk3/8/8/8/8/8/3r4/3RK3 b - - 0 1",
+			move: "d2d1",
+			want: rookVal,
+		},
+		{
+			name: "en passant",
+			fen:  "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1",
+			move: "e5d6",
+			want: pawnVal,
+		},
+		{
+			name: "safe promotion",
+			fen:  "4k3/1P6/8/8/8/8/8/4K3 w - - 0 1",
+			move: "b7b8q",
+			want: queenVal - pawnVal,
+		},
+		{
+			name: "defended promotion square",
+			fen:  "1rk5/P7/8/8/8/8/8/4K3 w - - 0 1",
+			move: "a7b8q",
+			want: rookVal - pawnVal,
+		},
+		{
+			name: "quiet move to a safe square",
+			fen:  "4k3/8/2p5/8/4N3/8/8/4K3 w - - 0 1",
+			move: "e4d6",
+			want: 0,
+		},
+		{
+			name: "quiet move onto a pawn's attack",
+			fen:  "4k3/8/2p5/8/3N4/8/8/4K3 w - - 0 1",
+			move: "d4b5",
+			want: -knightVal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := dragon.ParseFen(tt.fen)
+			move, err := dragon.ParseMove(tt.move)
+			if err != nil {
+				t.Fatalf("Could not parse move %v: %v", tt.move, err)
+			}
+
+			if got := SEE(&board, move); got != tt.want {
+				t.Errorf("SEE(%v) = %v, want %v\n%v", tt.move, got, tt.want, board.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/noahklein/chess/uci"
+)
+
+// Strength limiting, so weaker players have a sparring partner. Below full strength the
+// search is capped in depth and nodes, and the move is picked among the best root moves
+// with a random error that grows as the level drops: like a weaker player, the engine
+// misjudges close moves far more often than it blunders a piece.
+const (
+	maxSkill        = 20 // Full strength.
+	skillCandidates = 4  // Root moves to pick from below full strength.
+
+	// UCI_Elo range.
+	minElo = 800
+	maxElo = 2400
+)
+
+// eloLevels maps ratings to skill levels, interpolated in between. The gaps between
+// levels 0, 5, 10, 15, and 20 are measured with self-play matches, see elo/README.md.
+// The scale isn't anchored yet: full strength is pinned at maxElo until the puzzle
+// runner's -elo flag or scripts/stockfish-elo.sh rates a level, so UCI_Elo isn't
+// advertised. The 5 to 10 gap is a lower bound, level 10 won every game.
+var eloLevels = []struct {
+	elo   int
+	level float64
+}{
+	{-44, 0},
+	{592, 5},
+	{1423, 10},
+	{2131, 15},
+	{maxElo, maxSkill},
+}
+
+// Skill is the strength limit, set by the Skill Level, UCI_LimitStrength, and UCI_Elo
+// options. Only the main thread uses it.
+type Skill struct {
+	Level         int  // 0 to maxSkill.
+	LimitStrength bool // UCI_Elo overrides the skill level.
+	Elo           int
+
+	rand *rand.Rand
+}
+
+func NewSkill() *Skill {
+	return &Skill{
+		Level: maxSkill,
+		Elo:   maxElo,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (e *Engine) setSkill(option, value string) error {
+	if e.skill == nil {
+		e.skill = NewSkill()
+	}
+
+	if option == "uci_limitstrength" {
+		e.skill.LimitStrength = value == "true"
+		return nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	switch option {
+	case "skill level":
+		if i < 0 || i > maxSkill {
+			return fmt.Errorf("skill level must be in [0, %v], got %v", maxSkill, i)
+		}
+		e.skill.Level = i
+		e.UCI("info string Skill Level set to %v", i)
+	case "uci_elo":
+		if i < minElo || i > maxElo {
+			return fmt.Errorf("elo must be in [%v, %v], got %v", minElo, maxElo, i)
+		}
+		e.skill.Elo = i
+		e.UCI("info string UCI_Elo set to %v, skill level %.1f", i, eloLevel(i))
+	}
+	return nil
+}
+
+// level is the effective skill level, fractional when set by rating.
+func (s *Skill) level() float64 {
+	if s.LimitStrength {
+		return eloLevel(s.Elo)
+	}
+	return float64(s.Level)
+}
+
+func (s *Skill) enabled() bool {
+	return s.level() < maxSkill
+}
+
+func eloLevel(elo int) float64 {
+	if elo <= eloLevels[0].elo {
+		return eloLevels[0].level
+	}
+	for i := 1; i < len(eloLevels); i++ {
+		lo, hi := eloLevels[i-1], eloLevels[i]
+		if elo <= hi.elo {
+			t := float64(elo-lo.elo) / float64(hi.elo-lo.elo)
+			return lo.level + t*(hi.level-lo.level)
+		}
+	}
+	return maxSkill
+}
+
+// seed makes the picks reproducible, the same position gets the same picks.
+func (s *Skill) seed(hash uint64) {
+	s.rand.Seed(int64(hash))
+}
+
+// limit caps the search depth at the skill level, unless it was already limited further.
+func (s *Skill) limit(params *uci.SearchParams) {
+	depth := 1 + int(s.level())
+	if params.Depth == 0 || depth < params.Depth {
+		params.Depth = depth
+	}
+}
+
+// nodes is a soft node limit: no new iteration starts once it's spent, so there are
+// always lines to pick from. Doubles every 5/3 levels, 100 at level 0 and about 270k at
+// level 19.
+func (s *Skill) nodes() int {
+	return int(100 * math.Exp2(0.6*s.level()))
+}
+
+// pick chooses among the root lines, ranked best to worst. Each line's score is pushed
+// up by part of its distance from the best score, and by a random error up to the
+// spread of the scores, capped at a pawn. Both shrink linearly with the level, from
+// almost all of the distance at level 0 to nothing at full strength.
+func (s *Skill) pick(lines []uci.SearchResults) uci.SearchResults {
+	top := int(lines[0].Score)
+	spread := top - int(lines[len(lines)-1].Score)
+	if spread > pawnVal {
+		spread = pawnVal
+	}
+	weakness := int(6 * (maxSkill - s.level()))
+	if weakness < 1 {
+		weakness = 1
+	}
+
+	best, bestScore := 0, math.MinInt
+	for i, line := range lines {
+		push := (weakness*(top-int(line.Score)) + spread*s.rand.Intn(weakness)) / 128
+		if score := int(line.Score) + push; score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return lines[best]
+}
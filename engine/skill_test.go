@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+func TestEloLevel(t *testing.T) {
+	tests := []struct {
+		elo  int
+		want float64
+	}{
+		{-100, 0},
+		{592, 5},
+		{1423, 10},
+		{2131, 15},
+		{maxElo, maxSkill},
+	}
+	for _, tt := range tests {
+		if got := eloLevel(tt.elo); got != tt.want {
+			t.Errorf("eloLevel(%v) = %v, want %v", tt.elo, got, tt.want)
+		}
+	}
+}
+
+func TestSkillLimit(t *testing.T) {
+	s := NewSkill()
+	s.Level = 0
+
+	var params uci.SearchParams
+	s.limit(&params)
+	if params.Depth != 1 || s.nodes() != 100 {
+		t.Errorf("Level 0 limits = depth %v, nodes %v; want depth 1, nodes 100", params.Depth, s.nodes())
+	}
+
+	// A tighter limit is kept.
+	s.Level = 10
+	params = uci.SearchParams{Depth: 3}
+	s.limit(&params)
+	if params.Depth != 3 {
+		t.Errorf("Level 10 depth = %v, want 3", params.Depth)
+	}
+}
+
+func TestSkillPick(t *testing.T) {
+	lines := []uci.SearchResults{
+		{Move: "e2e4", Score: 30},
+		{Move: "d2d4", Score: 25},
+		{Move: "g1f3", Score: 10},
+		{Move: "f2f3", Score: -800},
+	}
+
+	picks := func(level int) map[string]int {
+		s := NewSkill()
+		s.Level = level
+		if level == maxSkill {
+			// Just below full strength.
+			s.LimitStrength, s.Elo = true, maxElo-1
+		}
+		s.rand = rand.New(rand.NewSource(1))
+
+		picked := map[string]int{}
+		for i := 0; i < 1000; i++ {
+			picked[s.pick(lines).Move]++
+		}
+		return picked
+	}
+
+	// Even beginners rarely hang a piece.
+	weak, strong := picks(0), picks(19)
+	if weak["f2f3"] > 20 || strong["f2f3"] > 0 {
+		t.Errorf("Picked a blunder too often: level 0 %v, level 19 %v", weak, strong)
+	}
+	if weak["e2e4"] == 1000 {
+		t.Errorf("Level 0 always picked the best move: %v", weak)
+	}
+	if strong["e2e4"] <= weak["e2e4"] {
+		t.Errorf("Level 19 picked the best move less often than level 0: %v, %v", strong, weak)
+	}
+	// No cliff between the top levels, the error fades out.
+	if strong["e2e4"] < 950 {
+		t.Errorf("Level 19 picked the best move %v times out of 1000: %v", strong["e2e4"], strong)
+	}
+	if top := picks(maxSkill); top["e2e4"] != 1000 {
+		t.Errorf("UCI_Elo %v picked %v", maxElo-1, top)
+	}
+}
+
+func TestSkillSearch(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, nil)
+	e.Debug(false)
+	if err := e.SetOption("Skill Level", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := e.IterDeep(context.Background(), uci.SearchParams{})
+	if len(results.Lines) != skillCandidates {
+		t.Fatalf("Got %v candidate lines, want %v", len(results.Lines), skillCandidates)
+	}
+	if results.Depth != 1 {
+		t.Errorf("Searched to depth %v, want 1", results.Depth)
+	}
+
+	var found bool
+	for _, line := range results.Lines {
+		found = found || line.Move == results.Move
+	}
+	if !found {
+		t.Errorf("Picked %v, not one of the candidate lines", results.Move)
+	}
+}
+
+// The candidate lines are only for the pick, the GUI gets the lines it asked for.
+func TestSkillMultiPV(t *testing.T) {
+	for _, multiPV := range []int{1, 2} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+
+		var e Engine
+		e.NewGame()
+		e.Position(dragon.Startpos, nil)
+		e.Debug(false)
+		e.Level = log.UCI
+		e.SetOption("Skill Level", "5")
+		e.SetOption("MultiPV", fmt.Sprint(multiPV))
+		out := make(chan string)
+		go func() {
+			b, _ := io.ReadAll(r)
+			out <- string(b)
+		}()
+
+		results := e.IterDeep(context.Background(), uci.SearchParams{})
+		w.Close()
+		os.Stdout = stdout
+		info := <-out
+
+		if len(results.Lines) != skillCandidates {
+			t.Errorf("MultiPV %v: got %v candidate lines, want %v", multiPV, len(results.Lines), skillCandidates)
+		}
+		if !strings.Contains(info, fmt.Sprintf("multipv %v ", multiPV)) {
+			t.Errorf("MultiPV %v: line %v not reported:\n%v", multiPV, multiPV, info)
+		}
+		if strings.Contains(info, fmt.Sprintf("multipv %v ", multiPV+1)) {
+			t.Errorf("MultiPV %v: candidate line reported:\n%v", multiPV, info)
+		}
+	}
+}
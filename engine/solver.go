@@ -0,0 +1,387 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+// Depth-first proof-number search (df-pn), a mate solver. Unlike alpha-beta it doesn't
+// score positions, it proves that the attacker mates in at most N moves against any
+// defence, or disproves it. Every node has a proof number, the minimum number of leaves
+// that must be proven to prove it, and a disproof number. The search always expands the
+// most-proving node, the one that lowers both the fastest, and only backs up when the
+// numbers cross the thresholds set by the parent.
+//
+// Repetitions are ignored inside the tree, a shortest mate never repeats a position.
+const (
+	pnInfinity         = 1 << 40
+	defaultSolverNodes = 1 << 20
+)
+
+// Proof is the outcome of a mate search.
+type Proof int
+
+const (
+	Unknown   Proof = iota // Ran out of nodes or was stopped.
+	Proven                 // The attacker mates.
+	Disproven              // The defender escapes.
+)
+
+// Proof numbers for the side to move: phi to prove that it reaches its goal, delta to
+// disprove it. For the attacker phi is the proof number, for the defender it's the
+// disproof number. A node's phi is the smallest delta of its children, its delta the
+// sum of their phi.
+type proofNumbers struct {
+	phi, delta int
+}
+
+var (
+	won  = proofNumbers{0, pnInfinity}
+	lost = proofNumbers{pnInfinity, 0}
+)
+
+type solverKey struct {
+	hash  uint64
+	moves int // Attacker moves left, proofs only hold for the same limit.
+}
+
+// Solver searches a board for forced mates. Not thread-safe.
+type Solver struct {
+	board         *dragon.Board
+	attackerWhite bool
+	rootMoves     []dragon.Move // Restricts the root moves if set, e.g. searchmoves.
+	table         map[solverKey]proofNumbers
+
+	nodes, nodeLimit int
+	ctx              context.Context
+	stop             bool
+	height           int
+}
+
+// NewSolver creates a solver for the side to move. The board is modified during the
+// search and restored afterwards.
+func NewSolver(board *dragon.Board, nodeLimit int) *Solver {
+	if nodeLimit <= 0 {
+		nodeLimit = defaultSolverNodes
+	}
+	return &Solver{
+		board:         board,
+		attackerWhite: board.Wtomove,
+		table:         map[solverKey]proofNumbers{},
+		nodeLimit:     nodeLimit,
+		ctx:           context.Background(),
+	}
+}
+
+// Solve looks for the shortest mate in at most maxMoves moves. Proven mates come with
+// the main line, the attacker mates as fast as possible and the defender resists as
+// long as possible.
+func (s *Solver) Solve(ctx context.Context, maxMoves int) (Proof, int, []dragon.Move) {
+	s.ctx, s.stop, s.height = ctx, false, 0
+
+	moves, proof := s.shortest(maxMoves)
+	if proof != Proven {
+		return proof, 0, nil
+	}
+	return Proven, moves, s.mainLine(moves)
+}
+
+// Nodes searched so far.
+func (s *Solver) Nodes() int { return s.nodes }
+
+// shortest finds the fewest moves the attacker to move needs to mate, up to maxMoves.
+// Each limit reuses the proofs and disproofs of the smaller ones.
+func (s *Solver) shortest(maxMoves int) (int, Proof) {
+	for moves := 1; moves <= maxMoves; moves++ {
+		switch s.prove(moves) {
+		case Proven:
+			return moves, Proven
+		case Unknown:
+			return 0, Unknown
+		}
+	}
+	return 0, Disproven
+}
+
+// prove searches the current node until it's proven or disproven, or the search stops.
+func (s *Solver) prove(moves int) Proof {
+	pn := s.mid(moves, pnInfinity, pnInfinity)
+	if s.attacking() {
+		pn.phi, pn.delta = pn.delta, pn.phi
+	}
+	// pn is now from the defender's view.
+	switch {
+	case pn.delta == 0:
+		return Proven
+	case pn.phi == 0:
+		return Disproven
+	}
+	return Unknown
+}
+
+type solverChild struct {
+	move dragon.Move
+	pn   proofNumbers
+}
+
+// mid is multiple iterative deepening: expand the most-proving child until the node's
+// phi or delta reaches its threshold.
+func (s *Solver) mid(moves int, thPhi, thDelta int) proofNumbers {
+	key := solverKey{s.board.Hash(), moves}
+	root := s.height == 0 && len(s.rootMoves) > 0
+	if pn, ok := s.table[key]; ok && !root && (pn.phi >= thPhi || pn.delta >= thDelta) {
+		return pn
+	}
+
+	s.nodes++
+	if s.nodes >= s.nodeLimit || (s.nodes&1023 == 0 && s.ctx.Err() != nil) {
+		s.stop = true
+	}
+
+	legal, inCheck := s.board.GenerateLegalMoves()
+	if root {
+		legal = s.rootMoves
+	}
+	pn, terminal := s.terminal(legal, inCheck, moves)
+	if terminal {
+		s.table[key] = pn
+		return pn
+	}
+
+	attacker := s.attacking()
+	childMoves := moves
+	if attacker {
+		childMoves--
+	}
+	children := make([]solverChild, 0, len(legal))
+	for _, move := range legal {
+		// Only checks mate on the last move.
+		if attacker && moves == 1 && !isCheck(s.board, move) {
+			continue
+		}
+		unapply := s.board.Apply(move)
+		children = append(children, solverChild{move, s.lookup(childMoves)})
+		unapply()
+	}
+
+	for {
+		pn = sumChildren(children)
+		if pn.phi >= thPhi || pn.delta >= thDelta || s.stop {
+			break
+		}
+
+		best, delta2 := mostProving(children)
+		child := &children[best]
+		childPhi := minPN(thDelta-pn.delta+child.pn.phi, pnInfinity)
+		// The 1+ε trick, stay in the child a little longer to avoid re-expanding it.
+		childDelta := minPN(thPhi, delta2+delta2/4+1)
+
+		unapply := s.board.Apply(child.move)
+		s.height++
+		child.pn = s.mid(childMoves, childPhi, childDelta)
+		s.height--
+		unapply()
+	}
+
+	if !root {
+		s.table[key] = pn
+	}
+	return pn
+}
+
+// terminal gives the proof numbers of a finished node. The side to move loses when
+// it's mated, and a stalemate, the fifty-move rule or running out of moves is a win for
+// the defender.
+func (s *Solver) terminal(legal []dragon.Move, inCheck bool, moves int) (proofNumbers, bool) {
+	attacker := s.attacking()
+	switch {
+	case len(legal) == 0 && inCheck:
+		return lost, true
+	case len(legal) == 0, s.board.Halfmoveclock >= 100, moves == 0:
+		if attacker {
+			return lost, true
+		}
+		return won, true
+	}
+	return proofNumbers{}, false
+}
+
+// lookup gets a node's proof numbers from the table, or estimates them for a new node.
+// The defender's mobility is the estimate, a check with few replies is the easiest to
+// prove.
+func (s *Solver) lookup(moves int) proofNumbers {
+	if pn, ok := s.table[solverKey{s.board.Hash(), moves}]; ok {
+		return pn
+	}
+
+	legal, inCheck := s.board.GenerateLegalMoves()
+	if pn, terminal := s.terminal(legal, inCheck, moves); terminal {
+		return pn
+	}
+	if s.attacking() {
+		return proofNumbers{1, 1}
+	}
+	return proofNumbers{1, len(legal)}
+}
+
+func (s *Solver) attacking() bool {
+	return s.board.Wtomove == s.attackerWhite
+}
+
+// sumChildren computes a node's proof numbers from its children.
+func sumChildren(children []solverChild) proofNumbers {
+	pn := proofNumbers{phi: pnInfinity}
+	for _, c := range children {
+		pn.phi = minPN(pn.phi, c.pn.delta)
+		if c.pn.phi >= pnInfinity {
+			pn.delta = pnInfinity
+		} else if pn.delta < pnInfinity {
+			// Stays finite, only a lost child disproves the node.
+			pn.delta = minPN(pn.delta+c.pn.phi, pnInfinity-1)
+		}
+	}
+	return pn
+}
+
+// mostProving returns the child with the smallest delta, and the second smallest delta.
+func mostProving(children []solverChild) (int, int) {
+	best, delta2 := 0, pnInfinity
+	for i := 1; i < len(children); i++ {
+		if d := children[i].pn.delta; d < children[best].pn.delta {
+			best, delta2 = i, children[best].pn.delta
+		} else if d < delta2 {
+			delta2 = d
+		}
+	}
+	return best, delta2
+}
+
+// mainLine follows a proven mate in the given number of moves: the attacker plays a
+// move that mates in time, the defender the reply that delays mate the longest.
+func (s *Solver) mainLine(moves int) []dragon.Move {
+	var line []dragon.Move
+	var undo []func()
+	defer func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	for moves > 0 && !s.stop {
+		legal, _ := s.board.GenerateLegalMoves()
+		if len(line) == 0 && len(s.rootMoves) > 0 {
+			legal = s.rootMoves
+		}
+		found := false
+		for _, move := range legal {
+			unapply := s.board.Apply(move)
+			s.height++
+			if s.prove(moves-1) == Proven {
+				line, undo, found = append(line, move), append(undo, unapply), true
+				break
+			}
+			s.height--
+			unapply()
+		}
+		if !found {
+			break
+		}
+
+		// The defender's longest resistance. A reply only needs its exact length if it's
+		// not mated as fast as the longest so far.
+		replies, _ := s.board.GenerateLegalMoves()
+		var reply dragon.Move
+		longest := 0
+		for _, move := range replies {
+			unapply := s.board.Apply(move)
+			s.height++
+			if longest == 0 || s.prove(longest) != Proven {
+				for n := longest + 1; n < moves && !s.stop; n++ {
+					if s.prove(n) == Proven {
+						reply, longest = move, n
+						break
+					}
+				}
+			}
+			s.height--
+			unapply()
+		}
+		if longest == 0 {
+			break // Mate, or the search stopped.
+		}
+		undo = append(undo, s.board.Apply(reply))
+		s.height++
+		line = append(line, reply)
+		moves = longest
+	}
+	return line
+}
+
+func minPN(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Solve is the solve debug command: prove or disprove a mate in at most the given
+// number of moves, with the mate solver alone. Can be cancelled with Stop.
+func (e *Engine) Solve(moves int) uci.SearchResults {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !e.startSearch(cancel) {
+		e.Error("Solve() called during a search, stop it first.")
+		return uci.SearchResults{}
+	}
+	defer e.endSearch()
+
+	e.rootMoves = e.rootMoveList(nil)
+	if len(e.rootMoves) == 0 {
+		e.Error("Solve() called on game that has already ended.")
+		return uci.SearchResults{}
+	}
+
+	start := time.Now()
+	result, proof := e.solveMate(ctx, uci.SearchParams{Mate: moves})
+	switch proof {
+	case Proven:
+		e.UCI(result.Print(start))
+	case Disproven:
+		e.UCI("info string no mate in %v, %v nodes", moves, result.Nodes)
+	case Unknown:
+		e.UCI("info string mate solver gave up after %v nodes", result.Nodes)
+	}
+	return result
+}
+
+// solveMate runs the mate solver for go mate, on the root moves.
+func (e *Engine) solveMate(ctx context.Context, params uci.SearchParams) (uci.SearchResults, Proof) {
+	board := *e.board
+	s := NewSolver(&board, params.Nodes)
+	if e.rootRestricted {
+		s.rootMoves = e.rootMoves
+	}
+
+	proof, moves, line := s.Solve(ctx, params.Mate)
+	if proof != Proven || len(line) == 0 {
+		return uci.SearchResults{Mate: NotMate, Nodes: s.Nodes()}, proof
+	}
+
+	pv := make([]string, len(line))
+	for i, move := range line {
+		pv[i] = move.String()
+	}
+	plies := 2*moves - 1
+	return uci.SearchResults{
+		Move: pv[0],
+		// Scored like alpha-beta, from the game's ply, so both report the same mate.
+		Score: -mateVal - e.ply - int16(plies),
+		Mate:  int16(moves),
+		PV:    pv,
+		Depth: plies, SelectiveDepth: plies,
+		Nodes: s.Nodes(),
+	}, proof
+}
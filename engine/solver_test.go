@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+func TestSolve(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		moves int
+		want  string
+		proof Proof
+	}{
+		{"mate in 2, w", "r2qkb1r/pp2nppp/3p4/2pNN1B1/2BnP3/3P4/PPP2PPP/R2bK2R w KQkq - 1 0", 2, "d5f6", Proven},
+		{"mate in 2, b", "6k1/pp4p1/2p5/2bp4/8/P5Pb/1P3rrP/2BRRN1K b - - 0 1", 2, "g2g1", Proven},
+		{"mate in 3, b", "r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", 3, "f8c5", Proven},
+		{"K+R vs K, mate in 8, w", "8/8/8/8/4K1k1/4R3/8/8 w - - 0 1", 8, "", Proven},
+		{"startpos, no mate in 2", dragon.Startpos, 2, "", Disproven},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			board := dragon.ParseFen(tt.fen)
+			s := NewSolver(&board, 0)
+
+			proof, moves, line := s.Solve(context.Background(), tt.moves)
+			if proof != tt.proof {
+				t.Fatalf("Solve() proof = %v, want %v", proof, tt.proof)
+			}
+			if proof != Proven {
+				return
+			}
+			if moves != tt.moves {
+				t.Errorf("Solve() found mate in %v, want %v", moves, tt.moves)
+			}
+			if tt.want != "" && line[0].String() != tt.want {
+				t.Errorf("Solve() played %v, want %v", line[0], tt.want)
+			}
+			if len(line) != 2*moves-1 {
+				t.Errorf("Main line %v has %v plies, want %v", line, len(line), 2*moves-1)
+			}
+
+			// The board is restored, play out the main line, it should end in mate.
+			start := dragon.ParseFen(tt.fen)
+			if board.ToFen() != start.ToFen() {
+				t.Fatalf("Board wasn't restored: got %v", board.ToFen())
+			}
+			for _, move := range line {
+				legal, _ := board.GenerateLegalMoves()
+				if !containsMove(legal, move) {
+					t.Fatalf("Main line %v has an illegal move %v", line, move)
+				}
+				board.Apply(move)
+			}
+			if legal, inCheck := board.GenerateLegalMoves(); len(legal) > 0 || !inCheck {
+				t.Errorf("Main line %v doesn't end in mate", line)
+			}
+		})
+	}
+}
+
+// go mate runs the solver on the root moves.
+func TestSolveRootMoves(t *testing.T) {
+	search := func(params uci.SearchParams) uci.SearchResults {
+		var e Engine
+		e.NewGame()
+		e.Position("r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", nil)
+		e.Debug(false)
+		e.Level = log.NONE
+
+		return e.IterDeep(context.Background(), params)
+	}
+
+	results := search(uci.SearchParams{Mate: 3})
+	if results.Move != "f8c5" || results.Mate != 3 {
+		t.Errorf("go mate 3 = %v, mate %v; want f8c5, mate 3", results.Move, results.Mate)
+	}
+	// Alpha-beta reports the same mate.
+	if ab := search(uci.SearchParams{Depth: 5}); ab.Mate != results.Mate || ab.Score != results.Score {
+		t.Errorf("Alpha-beta reports mate %v (score %v), the solver mate %v (score %v)",
+			ab.Mate, ab.Score, results.Mate, results.Score)
+	}
+	if len(results.PV) != 5 {
+		t.Errorf("Got PV %v, want the 5 ply main line", results.PV)
+	}
+
+	// Only the mating move mates.
+	results = search(uci.SearchParams{Mate: 3, SearchMoves: []string{"f6f2", "e5c6"}})
+	if results.Mate == 3 {
+		t.Errorf("go mate 3 searchmoves f6f2 e5c6 = %v, mate %v; want no mate", results.Move, results.Mate)
+	}
+}
+
+// go mate N nodes M shares the node limit between the solver and alpha-beta.
+func TestGoMateNodes(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	const nodes = 50000
+	results := e.IterDeep(context.Background(), uci.SearchParams{Mate: 5, Nodes: nodes, Depth: 100})
+	if results.Nodes > nodes+nodes/100 {
+		t.Errorf("go mate 5 nodes %v searched %v nodes", nodes, results.Nodes)
+	}
+	if results.Move == "" {
+		t.Error("No best move")
+	}
+}
+
+// solve must not take over a running search's root moves and cancel func.
+func TestSolveDuringSearch(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Position("r1b1kb1r/pppp1ppp/5q2/4n3/3KP3/2N3PN/PPP4P/R1BQ1B1R b kq - 0 1", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	e.searching = true
+	if result := e.Solve(3); result.Move != "" {
+		t.Errorf("solve ran during a search: got %v", result.Move)
+	}
+	if e.rootMoves != nil {
+		t.Error("solve replaced the running search's root moves")
+	}
+
+	e.searching = false
+	if result := e.Solve(3); result.Move != "f8c5" {
+		t.Errorf("solve 3 = %v, want f8c5", result.Move)
+	}
+	if e.searching {
+		t.Error("Search still marked as running after solve")
+	}
+}
@@ -1,15 +1,22 @@
 package engine
 
 import (
-	"sync"
+	"math"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/noahklein/dragon"
 )
 
 const (
-	MB        = 1024 * 1024
-	entrySize = uint64(unsafe.Sizeof(Entry{}))
+	MB         = 1024 * 1024
+	bucketSize = uint64(unsafe.Sizeof(bucket{}))
+
+	// Entries per bucket. A probe checks every entry in its bucket, which all share a
+	// cache line.
+	bucketLen = 4
+	// Generations wrap around, they're stored in 6 bits.
+	generationMask = 63
 )
 
 type NodeType uint8
@@ -29,30 +36,96 @@ type Entry struct {
 	best  dragon.Move
 }
 
+// Entries are packed into a single word so they can be read and written atomically,
+// from LSB:
+//
+//	16 bits: value
+//	16 bits: best move
+//	 8 bits: depth
+//	 2 bits: node type
+//	 6 bits: generation
+//	16 bits: key check, the top 16 bits of the hash
+//
+// The bucket index is taken from the low bits of the hash, so the key check and index
+// together verify the entry. A word of 0 is an empty slot.
+func (e Entry) pack(generation uint8) uint64 {
+	depth := e.depth
+	if depth > 127 {
+		depth = 127
+	} else if depth < -128 {
+		depth = -128
+	}
+
+	return uint64(uint16(e.value)) |
+		uint64(e.best)<<16 |
+		uint64(uint8(int8(depth)))<<32 |
+		uint64(e.flag&3)<<40 |
+		uint64(generation&generationMask)<<42 |
+		keyCheck(e.key)<<48
+}
+
+func unpack(hash, data uint64) Entry {
+	return Entry{
+		key:   hash,
+		value: int16(uint16(data)),
+		best:  dragon.Move(data >> 16),
+		depth: int(int8(uint8(data >> 32))),
+		flag:  NodeType(data>>40) & 3,
+	}
+}
+
+func keyCheck(hash uint64) uint64       { return hash >> 48 }
+func entryGeneration(data uint64) uint8 { return uint8(data>>42) & generationMask }
+
+type bucket [bucketLen]uint64
+
 // Transpositions is a transposition table (TT); used to memoize searched positions.
-// TTs add search-instability. Thread-safe.
+// TTs add search-instability. Thread-safe and lockless, readers and writers never block.
 type Transpositions struct {
-	sync.Mutex // TODO: this can be done locklessly.
-	table      []Entry
-	size       uint64
-	full       uint64
-	hits       int
+	table []bucket
+	size  uint64
+
+	// Bumped at the start of every search, so entries from previous searches can be
+	// evicted. Only written between searches.
+	generation uint8
 }
 
 func NewTranspositionTable(size uint64) *Transpositions {
 	if size == 0 {
 		size = 1
 	}
-	size = size * MB / entrySize
+	size = size * MB / bucketSize
 	return &Transpositions{
-		table: make([]Entry, size),
+		table: make([]bucket, size),
 		size:  size,
 	}
 }
 
 func (tt *Transpositions) key(hash uint64) uint64 { return hash % tt.size }
 
-// Always replaces previous entries.
+// NewSearch ages the table, must not be called during a search.
+func (tt *Transpositions) NewSearch() {
+	tt.generation = (tt.generation + 1) & generationMask
+}
+
+// age is how many searches ago an entry was written.
+func (tt *Transpositions) age(data uint64) int {
+	return int((tt.generation - entryGeneration(data)) & generationMask)
+}
+
+// worth ranks entries for replacement, deep entries from the current search are worth
+// keeping, old and shallow ones are not. Exact entries are the most expensive to find.
+func (tt *Transpositions) worth(data uint64) int {
+	e := unpack(0, data)
+	w := e.depth - 4*tt.age(data)
+	if e.flag == NodeExact {
+		w += 2
+	}
+	return w
+}
+
+// Add an entry to its bucket. An entry for the same position is replaced unless it was
+// searched deeper in the current search, otherwise the least valuable entry is evicted.
 func (tt *Transpositions) Add(ply int16, e Entry) {
 	// Adjust mate score.
 	if mateScore(e.value, ply) != NotMate {
@@ -63,50 +136,83 @@ func (tt *Transpositions) Add(ply int16, e Entry) {
 		}
 	}
 
-	tt.Lock()
-	defer tt.Unlock()
-
-	k := tt.key(e.key)
-	existing := tt.table[k]
+	b := &tt.table[tt.key(e.key)]
+	check := keyCheck(e.key)
 
-	isEmpty := existing.flag == NodeUnknown
-
-	// Don't replace good entries.
-	if !isEmpty && (e.depth < existing.depth) {
-		return
-	}
+	replace := 0
+	replaceWorth := math.MaxInt
+	for i := range b {
+		data := atomic.LoadUint64(&b[i])
+		if data == 0 {
+			replace = i
+			break
+		}
+		if data>>48 == check {
+			existing := unpack(e.key, data)
+			// Keep deeper entries from this search, unless we've found an exact score or a
+			// bound the deeper one is wrong about.
+			if tt.age(data) == 0 && e.depth < existing.depth && e.flag != NodeExact && !e.contradicts(existing) {
+				return
+			}
+			// Keep the best move if we don't have one.
+			if e.best == 0 {
+				e.best = existing.best
+			}
+			replace = i
+			break
+		}
 
-	if isEmpty {
-		tt.full++
+		if w := tt.worth(data); w < replaceWorth {
+			replace, replaceWorth = i, w
+		}
 	}
 
-	tt.table[k] = e
+	atomic.StoreUint64(&b[replace], e.pack(tt.generation))
 }
 
 func (tt *Transpositions) Get(hash uint64, ply int16) (Entry, bool) {
-	tt.Lock()
-	e := tt.table[tt.key(hash)]
-	ok := e.key == hash
-	if ok {
-		tt.hits++
-	}
-	tt.Unlock()
+	b := &tt.table[tt.key(hash)]
+	check := keyCheck(hash)
 
-	// Adjust mate score.
-	if mateScore(e.value, ply) != NotMate {
-		if e.value < 0 {
-			e.value += ply
-		} else {
-			e.value -= ply
+	for i := range b {
+		data := atomic.LoadUint64(&b[i])
+		if data == 0 || data>>48 != check {
+			continue
+		}
+		e := unpack(hash, data)
+
+		// Adjust mate score.
+		if mateScore(e.value, ply) != NotMate {
+			if e.value < 0 {
+				e.value += ply
+			} else {
+				e.value -= ply
+			}
 		}
+
+		return e, true
 	}
 
-	return e, ok
+	return Entry{}, false
+}
+
+// contradicts reports whether a bound is on the wrong side of another bound for the
+// same position. The other one came from a search that pruned what this one found, e.g.
+// a zero-window search that was refuted by the full-window re-search. Kept, it would
+// fail the same zero-window search high again on every visit.
+func (e Entry) contradicts(other Entry) bool {
+	switch {
+	case e.flag == NodeBeta && other.flag == NodeAlpha:
+		return e.value > other.value
+	case e.flag == NodeAlpha && other.flag == NodeBeta:
+		return e.value < other.value
+	}
+	return false
 }
 
-func (tt *Transpositions) GetEval(hash uint64, depth int, alpha, beta, ply int16) (int16, NodeType) {
-	e, ok := tt.Get(hash, ply)
-	if !ok || e.depth < depth {
+// Eval gets the score of an entry if it's usable in the current alpha-beta window.
+func (e Entry) Eval(depth int, alpha, beta int16) (int16, NodeType) {
+	if e.depth < depth {
 		return 0, NodeUnknown
 	}
 
@@ -114,24 +220,32 @@ func (tt *Transpositions) GetEval(hash uint64, depth int, alpha, beta, ply int16
 	case e.flag == NodeExact:
 		return e.value, NodeExact
 	case e.flag == NodeAlpha && e.value <= alpha:
-		return alpha, NodeAlpha
+		return e.value, NodeAlpha
 	case e.flag == NodeBeta && e.value >= beta:
-		return beta, NodeBeta
+		return e.value, NodeBeta
 	}
 
 	return 0, NodeUnknown
 }
 
+// PermillFull estimates how full the table is by sampling the first 1000 entries. Only
+// entries from the current search count, as the UCI hashfull spec expects.
 func (tt *Transpositions) PermillFull() int {
-	tt.Lock()
-	defer tt.Unlock()
-	return int(1000 * float64(tt.full) / float64(tt.size))
-}
+	n := uint64(1000 / bucketLen)
+	if tt.size < n {
+		n = tt.size
+	}
 
-func (tt *Transpositions) Hits() int {
-	tt.Lock()
-	defer tt.Unlock()
-	return tt.hits
+	var full uint64
+	for i := uint64(0); i < n; i++ {
+		for j := range tt.table[i] {
+			data := atomic.LoadUint64(&tt.table[i][j])
+			if data != 0 && entryGeneration(data) == tt.generation {
+				full++
+			}
+		}
+	}
+	return int(1000 * full / (n * bucketLen))
 }
 
 // History is a list of board hashes seen at each ply.
@@ -142,7 +256,7 @@ type History struct {
 // Draw checks for threefold repetitions and the fifty-move rule. The halfmove clock is
 // reset whenever an irreversible move is made, i.e. pawn moves, captures, castling, and
 // moves that lose castling rights.
-func (hst *History) Draw(hash uint64, ply int16, halfMoveClock uint8) bool {
+func (hst *History) Draw(hash uint64, halfMoveClock uint8) bool {
 	if halfMoveClock >= 100 {
 		return true
 	}
@@ -151,13 +265,17 @@ func (hst *History) Draw(hash uint64, ply int16, halfMoveClock uint8) bool {
 		return false
 	}
 
-	var count uint8
-	start := min(0, ply-int16(halfMoveClock+1))
-	pos := hst.positions[start:]
+	// Only positions since the last irreversible move can repeat, and only with the
+	// same side to move, i.e. every 2nd position back from the current one.
+	last := len(hst.positions) - 1
+	first := last - int(halfMoveClock)
+	if first < 0 {
+		first = 0
+	}
 
-	// Repetitions can only occur every 4th position.
-	for i := 0; i < len(pos); i += 4 {
-		if pos[i] != hash {
+	var count uint8
+	for i := last; i >= first; i -= 2 {
+		if hst.positions[i] != hash {
 			continue
 		}
 		count++
@@ -177,7 +295,8 @@ func (hst *History) Pop() {
 
 func (hst *History) Copy() *History {
 	var c History
-	c.positions = hst.positions
+	// Copy the backing array, otherwise concurrent pushes would clobber each other.
+	c.positions = append(make([]uint64, 0, len(hst.positions)+64), hst.positions...)
 	return &c
 }
 
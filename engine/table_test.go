@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/noahklein/dragon"
+)
+
+func TestTranspositionsPack(t *testing.T) {
+	tests := []Entry{
+		{key: 1 << 48, depth: 5, flag: NodeExact, value: 42, best: 0x1234},
+		{key: 2 << 48, depth: 0, flag: NodeAlpha, value: -infinity, best: 0},
+		{key: 3 << 48, depth: -3, flag: NodeBeta, value: infinity, best: 0x7fff},
+		{key: 0xffff << 48, depth: 127, flag: NodeExact, value: -1, best: 1},
+	}
+
+	for i, want := range tests {
+		gen := uint8(i * 21)
+		data := want.pack(gen)
+		if got := unpack(want.key, data); got != want {
+			t.Errorf("unpack(pack()) = %+v, want %+v", got, want)
+		}
+		if got := data >> 48; got != keyCheck(want.key) {
+			t.Errorf("Bad key check: got %x, want %x", got, keyCheck(want.key))
+		}
+		if got := entryGeneration(data); got != gen&generationMask {
+			t.Errorf("Bad generation: got %v, want %v", got, gen&generationMask)
+		}
+	}
+}
+
+func TestEntryEval(t *testing.T) {
+	tests := []struct {
+		name        string
+		entry       Entry
+		alpha, beta int16
+		want        int16
+		wantType    NodeType
+	}{
+		{"exact", Entry{depth: 5, flag: NodeExact, value: 30}, 0, 10, 30, NodeExact},
+		{"upper bound below alpha", Entry{depth: 5, flag: NodeAlpha, value: -50}, 0, 10, -50, NodeAlpha},
+		{"upper bound above alpha", Entry{depth: 5, flag: NodeAlpha, value: 5}, 0, 10, 0, NodeUnknown},
+		{"lower bound above beta", Entry{depth: 5, flag: NodeBeta, value: 80}, 0, 10, 80, NodeBeta},
+		{"lower bound below beta", Entry{depth: 5, flag: NodeBeta, value: 5}, 0, 10, 0, NodeUnknown},
+		{"too shallow", Entry{depth: 3, flag: NodeExact, value: 30}, 0, 10, 0, NodeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotType := tt.entry.Eval(4, tt.alpha, tt.beta)
+			if got != tt.want || gotType != tt.wantType {
+				t.Errorf("Eval() = %v, %v; want %v, %v", got, gotType, tt.want, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestTranspositionsReplacement(t *testing.T) {
+	tt := NewTranspositionTable(1)
+	tt.table, tt.size = tt.table[:1], 1 // Single bucket.
+
+	entry := func(key uint64, depth int) Entry {
+		return Entry{key: key << 48, depth: depth, flag: NodeAlpha, best: dragon.Move(key)}
+	}
+
+	// Fill the bucket.
+	for key := uint64(1); key <= bucketLen; key++ {
+		tt.Add(0, entry(key, int(key)+10))
+	}
+
+	// Shallow entry from the current search evicts the shallowest entry.
+	tt.Add(0, entry(100, 1))
+	if _, ok := tt.Get(1<<48, 0); ok {
+		t.Error("Shallowest entry was not evicted")
+	}
+	if _, ok := tt.Get(100<<48, 0); !ok {
+		t.Error("New entry was not added")
+	}
+
+	// Shallower entry for the same position doesn't replace a deeper one.
+	tt.Add(0, entry(2, 3))
+	if got, _ := tt.Get(2<<48, 0); got.depth != 12 {
+		t.Errorf("Deeper entry was replaced: got depth %v, want 12", got.depth)
+	}
+
+	// After a few searches old entries are worth less than fresh shallow ones.
+	tt.NewSearch()
+	tt.NewSearch()
+	tt.NewSearch()
+	if got := tt.PermillFull(); got != 0 {
+		t.Errorf("PermillFull() = %v, want 0 for entries from previous searches", got)
+	}
+	tt.Add(0, entry(200, 1))
+	if _, ok := tt.Get(100<<48, 0); ok {
+		t.Error("Oldest, shallowest entry was not evicted")
+	}
+	tt.Add(0, entry(300, 1))
+	if _, ok := tt.Get(200<<48, 0); !ok {
+		t.Error("Evicted a fresh entry instead of an old one")
+	}
+	if _, ok := tt.Get(2<<48, 0); ok {
+		t.Error("Old entry was not evicted")
+	}
+	if got, want := tt.PermillFull(), 2*1000/bucketLen; got != want {
+		t.Errorf("PermillFull() = %v, want %v", got, want)
+	}
+}
+
+// A shallower bound that contradicts a deeper one from the same search replaces it, the
+// deeper one came from a search that pruned the line.
+func TestTranspositionsContradiction(t *testing.T) {
+	tt := NewTranspositionTable(1)
+	upper := Entry{key: 1 << 48, depth: 8, flag: NodeAlpha, value: 0, best: 1}
+
+	tests := []struct {
+		name    string
+		entry   Entry
+		replace bool
+	}{
+		{"lower bound above", Entry{key: 1 << 48, depth: 6, flag: NodeBeta, value: 50}, true},
+		{"lower bound below", Entry{key: 1 << 48, depth: 6, flag: NodeBeta, value: -50}, false},
+		{"lower bound equal", Entry{key: 1 << 48, depth: 6, flag: NodeBeta, value: 0}, false},
+		{"upper bound", Entry{key: 1 << 48, depth: 6, flag: NodeAlpha, value: 50}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt.Add(0, upper)
+			tt.Add(0, tc.entry)
+			got, _ := tt.Get(1<<48, 0)
+			if replaced := got.depth == tc.entry.depth; replaced != tc.replace {
+				t.Errorf("Replaced = %v, want %v: got %+v", replaced, tc.replace, got)
+			}
+		})
+	}
+
+	// And the other way around.
+	tt.Add(0, Entry{key: 2 << 48, depth: 8, flag: NodeBeta, value: 0})
+	tt.Add(0, Entry{key: 2 << 48, depth: 6, flag: NodeAlpha, value: -50})
+	if got, _ := tt.Get(2<<48, 0); got.flag != NodeAlpha {
+		t.Errorf("Contradicted lower bound was kept: got %+v", got)
+	}
+}
+
+// Hammer the table from many goroutines, run with -race. Every entry's data is derived
+// from its key check, so a torn read would show up as an entry that doesn't match.
+func TestTranspositionsConcurrent(t *testing.T) {
+	const (
+		goroutines = 16
+		ops        = 20000
+	)
+	tt := NewTranspositionTable(1)
+	// Few buckets to force collisions between goroutines.
+	tt.table, tt.size = tt.table[:16], 16
+
+	entryFor := func(key uint64) Entry {
+		check := keyCheck(key)
+		return Entry{
+			key:   key,
+			depth: int(check % 64),
+			flag:  NodeExact,
+			value: int16(check % 1000),
+			best:  dragon.Move(check),
+		}
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed uint64) {
+			defer wg.Done()
+
+			key := seed
+			for i := 0; i < ops; i++ {
+				// Xorshift for cheap pseudo-random keys.
+				key ^= key << 13
+				key ^= key >> 7
+				key ^= key << 17
+
+				tt.Add(0, entryFor(key))
+				if got, ok := tt.Get(key, 0); ok && got != entryFor(key) {
+					t.Errorf("Torn entry: got %+v, want %+v", got, entryFor(key))
+					return
+				}
+				tt.PermillFull()
+			}
+		}(uint64(g + 1))
+	}
+	wg.Wait()
+
+	if full := tt.PermillFull(); full == 0 {
+		t.Errorf("PermillFull() = 0 after %v writes", goroutines*ops)
+	}
+}
+
+func TestHistoryDraw(t *testing.T) {
+	// An irreversible move, then a knight dance that returns to the same position every
+	// 4 plies, starting at an odd index.
+	var hst History
+	hst.Push(1)
+	for i := 0; i < 9; i++ {
+		hst.Push([]uint64{2, 3, 4, 5}[i%4])
+	}
+
+	tests := []struct {
+		name      string
+		halfMoves uint8
+		want      bool
+	}{
+		{"threefold", 8, true},
+		{"irreversible move in between", 7, false},
+		{"fifty-move rule", 100, true},
+	}
+	for _, tt := range tests {
+		if got := hst.Draw(2, tt.halfMoves); got != tt.want {
+			t.Errorf("%v: Draw() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	hst.Pop()
+	if hst.Draw(5, 8) {
+		t.Error("Twofold repetition reported as a draw")
+	}
+}
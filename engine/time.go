@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/noahklein/chess/uci"
+)
+
+const (
+	defaultThinkTime    = 5 * time.Second
+	defaultMoveOverhead = 30 * time.Millisecond
+	minThinkTime        = 10 * time.Millisecond
+
+	// Assumed moves left in sudden death games.
+	defaultMovesToGo = 30
+	maxMovesToGo     = 50
+	// Below this, play fast and live off the increment.
+	emergencyClock = 1 * time.Second
+
+	// Nodes per second of the deterministic clock.
+	deterministicNPS = 500_000
+)
+
+// timeManager decides how long to think on a move. The soft limit is the time we'd like
+// to spend, it's scaled after each iteration by how stable the search is. The hard limit
+// cancels the search. Thread-safe, ponderhit comes from the UCI loop.
+//
+// In deterministic mode the clock counts nodes instead of time, at deterministicNPS, so
+// the same search always stops at the same point.
+type timeManager struct {
+	sync.Mutex
+	start      time.Time
+	nodes      func() int // Searched nodes, set in deterministic mode.
+	startNodes int        // Nodes on the clock at the start.
+	soft, hard time.Duration
+	unlimited  bool // Infinite, depth, nodes or mate search without a clock.
+	fixed      bool // Movetime or default think time, only the hard limit stops the search.
+	pondering  bool // Limits don't apply until ponderhit.
+
+	singleMove bool // Only one legal move, no need to think.
+
+	// Search stability.
+	iterations int
+	bestMove   string
+	stability  int // Iterations since the best move changed.
+	prevScore  int16
+	scoreDrop  int16
+}
+
+func newTimeManager(params uci.SearchParams, whiteToMove bool, overhead time.Duration, ponder bool) *timeManager {
+	tm := &timeManager{
+		start:     params.Start,
+		pondering: params.Ponder,
+	}
+
+	t, inc := params.BlackTime, params.BlackInc
+	if whiteToMove {
+		t, inc = params.WhiteTime, params.WhiteInc
+	}
+
+	switch {
+	case params.Infinite:
+		tm.unlimited = true
+		return tm
+	case params.MoveTime > 0:
+		tm.soft = maxDuration(params.MoveTime-overhead, minThinkTime)
+		tm.hard, tm.fixed = tm.soft, true
+		return tm
+	case t == 0 && (params.Depth > 0 || params.Nodes > 0 || params.Mate > 0):
+		tm.unlimited = true
+		return tm
+	case t == 0:
+		tm.soft, tm.hard, tm.fixed = defaultThinkTime, defaultThinkTime, true
+		return tm
+	}
+
+	mtg := time.Duration(params.MovesToGo)
+	if mtg == 0 {
+		mtg = defaultMovesToGo
+	}
+	if mtg > maxMovesToGo {
+		mtg = maxMovesToGo
+	}
+
+	// Time we can spend on the remaining moves, keeping the overhead for each of them.
+	left := maxDuration(t+inc*(mtg-1)-overhead*(mtg+1), minThinkTime)
+	tm.soft = left / mtg
+	if ponder {
+		// We'll also be thinking on the opponent's time, spend a bit more.
+		tm.soft += tm.soft / 4
+	}
+
+	// Never bet too much of the clock on a single move.
+	tm.hard = minDuration(tm.soft*4, (t-overhead)*3/4)
+
+	if t < emergencyClock {
+		tm.soft = minDuration(tm.soft, inc/2+t/50)
+		tm.hard = minDuration(tm.hard, tm.soft*2)
+	}
+
+	tm.soft = maxDuration(minDuration(tm.soft, tm.hard), minThinkTime)
+	tm.hard = maxDuration(tm.hard, minThinkTime)
+	return tm
+}
+
+// HardLimit is how long until the search must be cancelled, false if there's no limit.
+func (tm *timeManager) HardLimit() (time.Duration, bool) {
+	tm.Lock()
+	defer tm.Unlock()
+	return tm.hard, !tm.unlimited && !tm.pondering
+}
+
+// PonderHit starts the clock, we're now thinking on our own time.
+func (tm *timeManager) PonderHit() {
+	tm.Lock()
+	defer tm.Unlock()
+	tm.pondering = false
+	tm.start = time.Now()
+	if tm.nodes != nil {
+		tm.startNodes = tm.nodes()
+	}
+}
+
+// Update is called by the main thread after each completed iteration.
+func (tm *timeManager) Update(result uci.SearchResults) {
+	tm.Lock()
+	defer tm.Unlock()
+
+	if tm.iterations > 0 {
+		tm.scoreDrop = tm.prevScore - result.Score
+	}
+	if result.Move == tm.bestMove {
+		tm.stability++
+	} else {
+		tm.bestMove, tm.stability = result.Move, 0
+	}
+	tm.prevScore = result.Score
+	tm.iterations++
+}
+
+// Stop reports whether the main thread should stop before starting the next iteration.
+func (tm *timeManager) Stop() bool {
+	tm.Lock()
+	defer tm.Unlock()
+
+	if tm.unlimited || tm.pondering {
+		return false
+	}
+	if tm.singleMove && tm.iterations > 0 {
+		return true
+	}
+	// Use all of a fixed think time, the hard limit cuts the last iteration.
+	if tm.fixed {
+		return false
+	}
+
+	limit := minDuration(tm.scaledSoft(), tm.hard)
+	// The next iteration usually takes longer than all the previous ones combined,
+	// don't start one we can't finish.
+	return tm.elapsed() >= limit/2
+}
+
+// elapsed is the time spent thinking, on the node clock in deterministic mode.
+func (tm *timeManager) elapsed() time.Duration {
+	if tm.nodes != nil {
+		return time.Duration(tm.nodes()-tm.startNodes) * time.Second / deterministicNPS
+	}
+	return time.Since(tm.start)
+}
+
+// clockNodes converts a duration to nodes on the deterministic clock.
+func clockNodes(d time.Duration) int {
+	return int(d * deterministicNPS / time.Second)
+}
+
+// Think longer when the best move keeps changing or the score is dropping, think less
+// when the search has settled.
+func (tm *timeManager) scaledSoft() time.Duration {
+	scale := 1.0
+	switch {
+	case tm.stability == 0:
+		scale = 1.6
+	case tm.stability == 1:
+		scale = 1.2
+	case tm.stability >= 6:
+		scale = 0.6
+	case tm.stability >= 3:
+		scale = 0.8
+	}
+
+	switch {
+	case tm.scoreDrop > pawnVal:
+		scale *= 2
+	case tm.scoreDrop > pawnVal/4:
+		scale *= 1.4
+	}
+
+	return time.Duration(float64(tm.soft) * scale)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
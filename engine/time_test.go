@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+)
+
+func TestTimeManagerLimits(t *testing.T) {
+	const overhead = 30 * time.Millisecond
+	tests := []struct {
+		name   string
+		params uci.SearchParams
+		ponder bool
+
+		wantSoft, wantHard time.Duration
+		wantUnlimited      bool
+	}{
+		{
+			name:     "movetime",
+			params:   uci.SearchParams{MoveTime: time.Second},
+			wantSoft: 970 * time.Millisecond, wantHard: 970 * time.Millisecond,
+		},
+		{
+			name:     "no clock",
+			params:   uci.SearchParams{},
+			wantSoft: defaultThinkTime, wantHard: defaultThinkTime,
+		},
+		{
+			name:          "depth without clock",
+			params:        uci.SearchParams{Depth: 10},
+			wantUnlimited: true,
+		},
+		{
+			name:          "infinite",
+			params:        uci.SearchParams{Infinite: true, WhiteTime: time.Minute},
+			wantUnlimited: true,
+		},
+		{
+			name:     "sudden death",
+			params:   uci.SearchParams{WhiteTime: 60930 * time.Millisecond},
+			wantSoft: 2 * time.Second, wantHard: 8 * time.Second,
+		},
+		{
+			name:     "sudden death, pondering",
+			params:   uci.SearchParams{WhiteTime: 60930 * time.Millisecond},
+			ponder:   true,
+			wantSoft: 2500 * time.Millisecond, wantHard: 10 * time.Second,
+		},
+		{
+			name:     "last move before time control",
+			params:   uci.SearchParams{WhiteTime: 10 * time.Second, MovesToGo: 1},
+			wantSoft: 7477500 * time.Microsecond, wantHard: 7477500 * time.Microsecond,
+		},
+		{
+			name:     "emergency",
+			params:   uci.SearchParams{WhiteTime: 500 * time.Millisecond, WhiteInc: 100 * time.Millisecond},
+			wantSoft: 60 * time.Millisecond, wantHard: 120 * time.Millisecond,
+		},
+		{
+			name:     "flagging",
+			params:   uci.SearchParams{WhiteTime: 20 * time.Millisecond},
+			wantSoft: minThinkTime, wantHard: minThinkTime,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := newTimeManager(tt.params, true, overhead, tt.ponder)
+			if tm.unlimited != tt.wantUnlimited {
+				t.Fatalf("unlimited = %v, want %v", tm.unlimited, tt.wantUnlimited)
+			}
+			if tt.wantUnlimited {
+				return
+			}
+			if tm.soft != tt.wantSoft || tm.hard != tt.wantHard {
+				t.Errorf("Limits = %v, %v; want %v, %v", tm.soft, tm.hard, tt.wantSoft, tt.wantHard)
+			}
+		})
+	}
+}
+
+func TestTimeManagerStability(t *testing.T) {
+	tm := newTimeManager(uci.SearchParams{WhiteTime: time.Minute}, true, 0, false)
+
+	tm.Update(uci.SearchResults{Move: "e2e4", Score: 30})
+	unstable := tm.scaledSoft()
+	for i := 0; i < 6; i++ {
+		tm.Update(uci.SearchResults{Move: "e2e4", Score: 30})
+	}
+	stable := tm.scaledSoft()
+	if stable >= tm.soft || unstable <= tm.soft {
+		t.Errorf("Stability not applied: unstable %v, stable %v, soft %v", unstable, stable, tm.soft)
+	}
+
+	tm.Update(uci.SearchResults{Move: "e2e4", Score: -200})
+	if dropped := tm.scaledSoft(); dropped <= stable {
+		t.Errorf("Score drop didn't extend think time: got %v, was %v", dropped, stable)
+	}
+}
+
+// Fixed think times are used up, the hard limit stops the search.
+func TestTimeManagerFixed(t *testing.T) {
+	for _, params := range []uci.SearchParams{{MoveTime: time.Second}, {}} {
+		tm := newTimeManager(params, true, defaultMoveOverhead, false)
+		tm.Update(uci.SearchResults{Move: "e2e4", Score: 30})
+		tm.start = time.Now().Add(-tm.hard * 9 / 10)
+		if tm.Stop() {
+			t.Errorf("%+v: stopped after %v of %v", params, tm.elapsed(), tm.hard)
+		}
+	}
+
+	var e Engine
+	e.NewGame()
+	e.Debug(false)
+	e.Level = log.NONE
+
+	const moveTime = 500 * time.Millisecond
+	start := time.Now()
+	e.Go(uci.SearchParams{MoveTime: moveTime})
+	if elapsed := time.Since(start); elapsed < moveTime-2*defaultMoveOverhead || elapsed > moveTime+100*time.Millisecond {
+		t.Errorf("go movetime %v took %v", moveTime, elapsed)
+	}
+}
+
+func TestGoSingleMove(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	// Black king can only go to g8.
+	e.Position("7k/8/6K1/8/8/8/8/R7 b - - 0 1", nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	if moves, _ := e.GenMoves(); len(moves) != 1 {
+		t.Fatalf("Want a single legal move, got %v", moves)
+	}
+
+	start := time.Now()
+	result := e.Go(uci.SearchParams{BlackTime: time.Minute, WhiteTime: time.Minute})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Spent %v on a single legal move", elapsed)
+	}
+	if result.Move != "h8g8" {
+		t.Errorf("Wrong move: got %v, want h8g8", result.Move)
+	}
+}
+
+func TestMoveOverheadOption(t *testing.T) {
+	var e Engine
+	e.NewGame()
+	e.Level = log.NONE
+	if e.moveOverhead != defaultMoveOverhead {
+		t.Errorf("Default move overhead = %v, want %v", e.moveOverhead, defaultMoveOverhead)
+	}
+
+	if err := e.SetOption("move overhead", "0"); err != nil {
+		t.Fatalf("Move Overhead 0 rejected: %v", err)
+	}
+	e.NewGame()
+	if e.moveOverhead != 0 {
+		t.Errorf("Move overhead after ucinewgame = %v, want 0", e.moveOverhead)
+	}
+
+	if err := e.SetOption("move overhead", "-1"); err == nil {
+		t.Error("Negative move overhead accepted")
+	}
+}
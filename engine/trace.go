@@ -0,0 +1,323 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+// Search tracing, to see why the search pruned or reduced a move. The tracer records
+// the alpha-beta tree of the last iteration below a line of moves, with each node's
+// window, static eval, TT hit and the pruning, reduction and extension decisions taken
+// there. Quiescence search isn't recorded, only its score.
+//
+// Tracing is off unless e.trace is set, then AlphaBeta only pays for a nil check and
+// the note calls are inlined nil checks.
+const (
+	maxTraceNodes = 20000
+
+	traceJSON = "trace.json"
+	traceDOT  = "trace.dot"
+)
+
+// TraceNode is an AlphaBeta call. A node without a move is a re-search of its parent's
+// position, by IID, a singular extension or a null move verification; see the
+// parent's events.
+type TraceNode struct {
+	Move       string       `json:"move,omitempty"`
+	Depth      int          `json:"depth"`
+	Alpha      int16        `json:"alpha"`
+	Beta       int16        `json:"beta"`
+	StaticEval *int16       `json:"static_eval,omitempty"`
+	TTHit      bool         `json:"tt_hit,omitempty"`
+	TTMove     string       `json:"tt_move,omitempty"`
+	Score      int16        `json:"score"`
+	Events     []TraceEvent `json:"events,omitempty"`
+	Children   []*TraceNode `json:"children,omitempty"`
+	height     int16
+}
+
+// TraceEvent is a decision taken at a node, e.g. {"lmr", "g1f3", 2} reduced g1f3 by 2
+// plies.
+type TraceEvent struct {
+	Event string `json:"event"`
+	Move  string `json:"move,omitempty"`
+	Value int    `json:"value"`
+}
+
+// Tracer records the search tree below a line of moves from the root, at the given
+// root depth. Only the main thread traces.
+type Tracer struct {
+	Depth     int        `json:"depth"` // Root depth of the traced iteration.
+	Line      []string   `json:"line"`  // Only nodes on or below this line are recorded.
+	Root      *TraceNode `json:"root"`
+	Nodes     int        `json:"nodes"`
+	Truncated bool       `json:"truncated"` // Stopped recording at maxTraceNodes.
+
+	stack []*TraceNode // Open nodes, nil if not recorded.
+}
+
+func NewTracer(depth int, line []string) *Tracer {
+	return &Tracer{Depth: depth, Line: line}
+}
+
+// root starts recording a root search, replacing the previous one at the same depth.
+// Other depths aren't recorded.
+func (t *Tracer) root(depth, alpha, beta int16) {
+	t.stack = t.stack[:0]
+	if int(depth) != t.Depth {
+		t.stack = append(t.stack, nil)
+		return
+	}
+	t.Root = &TraceNode{Depth: int(depth), Alpha: alpha, Beta: beta}
+	t.Nodes, t.Truncated = 1, false
+	t.stack = append(t.stack, t.Root)
+}
+
+// enter opens a node at the given height, reached by move.
+func (t *Tracer) enter(height int16, move dragon.Move, alpha, beta int16, depth int) {
+	parent := t.stack[len(t.stack)-1]
+	if parent == nil {
+		t.stack = append(t.stack, nil)
+		return
+	}
+
+	node := &TraceNode{Depth: depth, Alpha: alpha, Beta: beta, height: height}
+	if height > parent.height {
+		node.Move = traceMove(move)
+		// Off the traced line.
+		if int(height) <= len(t.Line) && node.Move != t.Line[height-1] {
+			t.stack = append(t.stack, nil)
+			return
+		}
+	}
+	if t.Nodes >= maxTraceNodes {
+		t.Truncated = true
+		t.stack = append(t.stack, nil)
+		return
+	}
+
+	t.Nodes++
+	parent.Children = append(parent.Children, node)
+	t.stack = append(t.stack, node)
+}
+
+// exit closes the current node with its score.
+func (t *Tracer) exit(score int16) {
+	if node := t.stack[len(t.stack)-1]; node != nil {
+		node.Score = score
+	}
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+func (t *Tracer) current() *TraceNode {
+	if len(t.stack) == 0 {
+		return nil
+	}
+	return t.stack[len(t.stack)-1]
+}
+
+// The hooks below are called from the search, they're nil checks when tracing is off.
+
+// note records a decision at the current node.
+func (t *Tracer) note(event string, value int) {
+	if t != nil {
+		t.addEvent(event, 0, value)
+	}
+}
+
+// noteMove records a decision about a move at the current node.
+func (t *Tracer) noteMove(event string, move dragon.Move, value int) {
+	if t != nil {
+		t.addEvent(event, move, value)
+	}
+}
+
+func (t *Tracer) staticEval(eval int16) {
+	if t != nil {
+		t.setStaticEval(eval)
+	}
+}
+
+func (t *Tracer) ttHit(entry Entry) {
+	if t != nil {
+		t.setTTHit(entry)
+	}
+}
+
+func (t *Tracer) addEvent(event string, move dragon.Move, value int) {
+	node := t.current()
+	if node == nil {
+		return
+	}
+	ev := TraceEvent{Event: event, Value: value}
+	if move != 0 {
+		ev.Move = move.String()
+	}
+	node.Events = append(node.Events, ev)
+}
+
+func (t *Tracer) setStaticEval(eval int16) {
+	if node := t.current(); node != nil {
+		node.StaticEval = &eval
+	}
+}
+
+func (t *Tracer) setTTHit(entry Entry) {
+	if node := t.current(); node != nil {
+		node.TTHit = true
+		if entry.best != 0 {
+			node.TTMove = entry.best.String()
+		}
+	}
+}
+
+func traceMove(move dragon.Move) string {
+	if move == 0 {
+		return "null"
+	}
+	return move.String()
+}
+
+// traceAlphaBeta wraps an AlphaBeta call in a trace node.
+func (e *Engine) traceAlphaBeta(ctx context.Context, alpha, beta int16, depth int) int16 {
+	height := e.height()
+	var move dragon.Move
+	if height > 0 && height <= maxHeight {
+		move = e.stack[height-1].move
+	}
+
+	e.trace.enter(height, move, alpha, beta, depth)
+	score := e.alphaBeta(ctx, alpha, beta, depth)
+	e.trace.exit(score)
+	return score
+}
+
+// WriteJSON writes the traced tree as indented JSON.
+func (t *Tracer) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// WriteDOT renders the traced tree for Graphviz. Pruned moves are dashed leaves,
+// nodes that failed high are red.
+func (t *Tracer) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph trace {\n\tnode [shape=box, fontname=monospace];\n")
+
+	id := 0
+	var walk func(node *TraceNode) int
+	walk = func(node *TraceNode) int {
+		me := id
+		id++
+
+		label := node.Move
+		if label == "" {
+			label = "root"
+			if node != t.Root {
+				label = "re-search"
+			}
+		}
+		lines := []string{label, fmt.Sprintf("d=%v [%v, %v] -> %v", node.Depth, node.Alpha, node.Beta, node.Score)}
+		if node.StaticEval != nil {
+			lines = append(lines, fmt.Sprintf("eval %v", *node.StaticEval))
+		}
+		if node.TTHit {
+			lines = append(lines, "tt "+node.TTMove)
+		}
+		for _, ev := range node.Events {
+			if ev.Event == "pruned" {
+				continue
+			}
+			if ev.Move != "" {
+				lines = append(lines, fmt.Sprintf("%v %v %v", ev.Event, ev.Move, ev.Value))
+			} else {
+				lines = append(lines, fmt.Sprintf("%v %v", ev.Event, ev.Value))
+			}
+		}
+		attrs := ""
+		if node.Score >= node.Beta {
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(&b, "\tn%v [label=%q%v];\n", me, strings.Join(lines, "\n"), attrs)
+
+		for _, child := range node.Children {
+			fmt.Fprintf(&b, "\tn%v -> n%v;\n", me, walk(child))
+		}
+		for _, ev := range node.Events {
+			if ev.Event == "pruned" {
+				fmt.Fprintf(&b, "\tn%v [label=%q, style=dashed];\n\tn%v -> n%v [style=dashed];\n", id, ev.Move+"\npruned", me, id)
+				id++
+			}
+		}
+		return me
+	}
+	if t.Root != nil {
+		walk(t.Root)
+	}
+
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Trace is the trace debug command: search to depth, tracing the last iteration below
+// line, and write the tree to trace.json and trace.dot. The search is single-threaded
+// so the trace is reproducible. Can be cancelled with Stop, then nothing is written.
+func (e *Engine) Trace(depth int, line []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !e.startSearch(cancel) {
+		e.Error("Trace() called during a search, stop it first.")
+		return
+	}
+	defer e.endSearch()
+
+	if moves, _ := e.GenMoves(); len(moves) == 0 {
+		e.Error("Trace() called on game that has already ended.")
+		return
+	}
+
+	threads := e.threads
+	e.threads, e.trace = 1, NewTracer(depth, line)
+	defer func() { e.threads, e.trace = threads, nil }()
+
+	result := e.IterDeep(ctx, uci.SearchParams{Depth: depth})
+	if ctx.Err() != nil {
+		e.UCI("info string trace: stopped, best move %v", result.Move)
+		return
+	}
+	if e.trace.Root == nil {
+		e.UCI("info string trace: search ended before depth %v, best move %v", depth, result.Move)
+		return
+	}
+
+	if err := writeFile(traceJSON, e.trace.WriteJSON); err != nil {
+		e.Error("Failed to write %v: %v", traceJSON, err)
+		return
+	}
+	if err := writeFile(traceDOT, e.trace.WriteDOT); err != nil {
+		e.Error("Failed to write %v: %v", traceDOT, err)
+		return
+	}
+	e.UCI("info string trace: %v nodes (truncated: %v), wrote %v and %v", e.trace.Nodes, e.trace.Truncated, traceJSON, traceDOT)
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahklein/chess/log"
+	"github.com/noahklein/chess/uci"
+	"github.com/noahklein/dragon"
+)
+
+func TestTrace(t *testing.T) {
+	search := func(tracer *Tracer) uci.SearchResults {
+		var e Engine
+		e.NewGame()
+		e.Position(dragon.Startpos, []string{"e2e4", "e7e5"})
+		e.Debug(false)
+		e.Level = log.NONE
+		e.trace = tracer
+
+		return e.IterDeep(context.Background(), uci.SearchParams{Depth: 5})
+	}
+
+	tracer := NewTracer(5, []string{"g1f3"})
+	traced, untraced := search(tracer), search(nil)
+	if traced.Move != untraced.Move || traced.Score != untraced.Score || traced.Nodes != untraced.Nodes {
+		t.Errorf("Tracing changed the search: got %v %v (%v nodes), want %v %v (%v nodes)",
+			traced.Move, traced.Score, traced.Nodes, untraced.Move, untraced.Score, untraced.Nodes)
+	}
+
+	if tracer.Root == nil || len(tracer.Root.Children) == 0 {
+		t.Fatal("Nothing traced")
+	}
+	count := 0
+	var walk func(node *TraceNode)
+	walk = func(node *TraceNode) {
+		count++
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(tracer.Root)
+	if count != tracer.Nodes {
+		t.Errorf("Counted %v nodes, tracer has %v", count, tracer.Nodes)
+	}
+	for _, child := range tracer.Root.Children {
+		if child.Move != "g1f3" {
+			t.Errorf("Traced root move %v off the line g1f3", child.Move)
+		}
+	}
+
+	var js, dot bytes.Buffer
+	if err := tracer.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Tracer
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if err := tracer.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dot.String(), "digraph trace {") || strings.Count(dot.String(), "->") < tracer.Nodes-1 {
+		t.Errorf("Bad DOT output:\n%v", dot.String())
+	}
+}
+
+func TestTraceStop(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var e Engine
+	e.NewGame()
+	e.Position(dragon.Startpos, nil)
+	e.Debug(false)
+	e.Level = log.NONE
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Trace(50, []string{"e2e4"})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	e.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Trace didn't stop")
+	}
+
+	for _, path := range []string{traceJSON, traceDOT} {
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("Stopped trace wrote %v", path)
+		}
+	}
+}
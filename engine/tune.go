@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tuning holds search parameters that are exposed as UCI spin options, so they can be
+// tuned by self-play without rebuilding the engine. Depths are in plies, margins in
+// centipawns.
+type Tuning struct {
+	// Reverse futility pruning: the static eval beats beta by a margin per ply.
+	RFPDepth, RFPMargin int
+	// Razoring: the static eval is so far below alpha that only captures can save us.
+	RazorDepth, RazorMargin int
+	// ProbCut: a capture beats beta by a margin at reduced depth.
+	ProbCutDepth, ProbCutMargin, ProbCutImproving int
+	// Null move: reduced by NullBase + depth / NullDivisor plies, another ply per
+	// NullEvalDivisor the static eval is above beta. Verified from NullVerifyDepth.
+	NullBase, NullDivisor, NullEvalDivisor, NullVerifyDepth int
+	// Internal iterative deepening: nodes without a TT move from this depth.
+	IIDDepth int
+
+	// Late move pruning: quiet moves after the first LMPBase + depth² are skipped.
+	LMPDepth, LMPBase int
+	// History pruning: quiet moves with history below -margin * depth are skipped.
+	HistoryPruneDepth, HistoryPruneMargin int
+	// SEE pruning: moves losing more than a margin per ply of material are skipped.
+	SEEPruneDepth, SEEQuietMargin, SEECaptureMargin int
+
+	// Late move reductions: LMRBase + log(depth) * log(move number) / LMRDivisor plies,
+	// both in hundredths. Moves with a good history are reduced 1 ply less per LMRHistory.
+	LMRBase, LMRDivisor, LMRHistory int
+
+	// Built from the LMR coefficients, [depth][move number].
+	reductions [maxReduction][maxReduction]int
+}
+
+// Depths and move numbers beyond this share the last entry of the reduction table.
+const maxReduction = 64
+
+func init() {
+	defaultTuning.initReductions()
+}
+
+// initReductions rebuilds the late move reduction table from the LMR coefficients.
+func (t *Tuning) initReductions() {
+	for d := 1; d < maxReduction; d++ {
+		for m := 1; m < maxReduction; m++ {
+			r := float64(t.LMRBase)/100 + math.Log(float64(d))*math.Log(float64(m))*100/float64(t.LMRDivisor)
+			t.reductions[d][m] = int(r)
+		}
+	}
+}
+
+var defaultTuning = Tuning{
+	RFPDepth: 6, RFPMargin: 80,
+	RazorDepth: 3, RazorMargin: 200,
+	ProbCutDepth: 5, ProbCutMargin: 180, ProbCutImproving: 60,
+	NullBase: 3, NullDivisor: 3, NullEvalDivisor: 200, NullVerifyDepth: 10,
+	IIDDepth: 5,
+	LMPDepth: 6, LMPBase: 3,
+	HistoryPruneDepth: 3, HistoryPruneMargin: 2000,
+	SEEPruneDepth: 6, SEEQuietMargin: 60, SEECaptureMargin: 100,
+	LMRBase: 75, LMRDivisor: 225, LMRHistory: 8192,
+}
+
+type tunable struct {
+	name     string
+	value    func(t *Tuning) *int
+	min, max int
+}
+
+var tunables = []tunable{
+	{"RFP Depth", func(t *Tuning) *int { return &t.RFPDepth }, 0, 20},
+	{"RFP Margin", func(t *Tuning) *int { return &t.RFPMargin }, 0, 1000},
+	{"Razor Depth", func(t *Tuning) *int { return &t.RazorDepth }, 0, 20},
+	{"Razor Margin", func(t *Tuning) *int { return &t.RazorMargin }, 0, 1000},
+	{"ProbCut Depth", func(t *Tuning) *int { return &t.ProbCutDepth }, 0, 100},
+	{"ProbCut Margin", func(t *Tuning) *int { return &t.ProbCutMargin }, 0, 1000},
+	{"ProbCut Improving", func(t *Tuning) *int { return &t.ProbCutImproving }, 0, 1000},
+	{"Null Move Base", func(t *Tuning) *int { return &t.NullBase }, 1, 10},
+	{"Null Move Divisor", func(t *Tuning) *int { return &t.NullDivisor }, 1, 20},
+	{"Null Move Eval Divisor", func(t *Tuning) *int { return &t.NullEvalDivisor }, 1, 1000},
+	{"Null Move Verify Depth", func(t *Tuning) *int { return &t.NullVerifyDepth }, 0, 100},
+	{"IID Depth", func(t *Tuning) *int { return &t.IIDDepth }, 2, 100},
+	{"LMP Depth", func(t *Tuning) *int { return &t.LMPDepth }, 0, 20},
+	{"LMP Base", func(t *Tuning) *int { return &t.LMPBase }, 0, 100},
+	{"History Prune Depth", func(t *Tuning) *int { return &t.HistoryPruneDepth }, 0, 20},
+	{"History Prune Margin", func(t *Tuning) *int { return &t.HistoryPruneMargin }, 0, 3 * maxHistory},
+	{"SEE Prune Depth", func(t *Tuning) *int { return &t.SEEPruneDepth }, 0, 20},
+	{"SEE Quiet Margin", func(t *Tuning) *int { return &t.SEEQuietMargin }, 0, 1000},
+	{"SEE Capture Margin", func(t *Tuning) *int { return &t.SEECaptureMargin }, 0, 1000},
+	{"LMR Base", func(t *Tuning) *int { return &t.LMRBase }, 0, 500},
+	{"LMR Divisor", func(t *Tuning) *int { return &t.LMRDivisor }, 50, 1000},
+	{"LMR History", func(t *Tuning) *int { return &t.LMRHistory }, 1, 3 * maxHistory},
+}
+
+// setTunable sets a tuning option, reports false if the option isn't a tunable.
+func (e *Engine) setTunable(option, value string) (bool, error) {
+	for _, t := range tunables {
+		if strings.ToLower(t.name) != option {
+			continue
+		}
+
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return true, err
+		}
+		if i < t.min || i > t.max {
+			return true, fmt.Errorf("%v must be in [%v, %v], got %v", t.name, t.min, t.max, i)
+		}
+		if e.tune == nil {
+			tune := defaultTuning
+			e.tune = &tune
+		}
+		*t.value(e.tune) = i
+		e.tune.initReductions()
+		e.UCI("info string %v set to %v", t.name, i)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (e *Engine) printTunables() {
+	for _, t := range tunables {
+		e.UCI("option name %v type spin default %v min %v max %v", t.name, *t.value(&defaultTuning), t.min, t.max)
+	}
+}
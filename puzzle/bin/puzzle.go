@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +29,9 @@ var (
 	think  = flag.Duration("think", 5*time.Second, "how long to think")
 	rating = flag.Int("rating", 0, "min rating of puzzles")
 	length = flag.Int("length", 0, "puzzle length filter, must be even; 0 for all")
+	skill  = flag.Int("skill", 20, "skill level, 0-20")
+	limElo = flag.Int("elo", 0, "limit strength to this elo; 0 for full strength")
+	solve  = flag.Bool("solve", false, "prove mate puzzles with the mate solver")
 
 	tsearch = flag.String("tsearch", "", "search for tags")
 	verbose = flag.Int("v", 0, "log level, -1 to disable logging")
@@ -98,6 +102,19 @@ func main() {
 		e.Position(p.Fen, nil)
 		e.Level = log.Level(*verbose)
 		e.SetOption("Hash", "128")
+		e.SetOption("Skill Level", strconv.Itoa(*skill))
+		if *limElo > 0 {
+			e.SetOption("UCI_LimitStrength", "true")
+			e.SetOption("UCI_Elo", strconv.Itoa(*limElo))
+		}
+
+		if *solve {
+			if !solveMate(pNum, p) {
+				correct--
+				failedIDs = append(failedIDs, p.ID)
+			}
+			continue
+		}
 
 		var failed bool
 		var movesCompleted string
@@ -161,6 +178,51 @@ func main() {
 	}
 }
 
+// solveMate proves a mate puzzle's mate length with the mate solver. The solver finds
+// the shortest mate, so a shorter one than tagged is also a failure.
+func solveMate(pNum int, p puzzledb.Puzzle) bool {
+	want := mateLength(p)
+	if want == 0 {
+		log.Yellow("%6d) Skipped %s, not a mate puzzle", pNum+1, p.ID)
+		return true
+	}
+
+	var e engine.Engine
+	e.NewGame()
+	e.Level = log.Level(*verbose)
+	e.Position(p.Fen, p.Moves[:1])
+	result := e.Solve(want)
+
+	switch {
+	case result.Mate == int16(want):
+		log.Green("%6d) Proved %s mate in %v: %v", pNum+1, p.ID, want, strings.Join(result.PV, " "))
+		return true
+	case result.Move == "":
+		log.Red("%6d) Failed %s, no mate in %v", pNum+1, p.ID, want)
+	default:
+		log.Red("%6d) Failed %s, found mate in %v, tagged mate in %v: %v", pNum+1, p.ID, result.Mate, want, strings.Join(result.PV, " "))
+	}
+	log.Red(puzzledb.LichessUrl(p.Fen))
+	return false
+}
+
+// mateLength gets the mate length from the puzzle's tags, 0 if it's not a mate puzzle.
+func mateLength(p puzzledb.Puzzle) int {
+	for _, theme := range p.Themes {
+		if !strings.HasPrefix(theme, "mateIn") {
+			continue
+		}
+		if n, err := strconv.Atoi(theme[len("mateIn"):]); err == nil {
+			return n
+		}
+	}
+	// Longer mates are only tagged mate, the solution is the whole puzzle.
+	if contains(p.Themes, "mate") {
+		return len(p.Moves) / 2
+	}
+	return 0
+}
+
 func tagSearch(tag string) {
 	var found = map[string]struct{}{}
 
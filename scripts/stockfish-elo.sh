@@ -1,16 +1,23 @@
 #!/usr/bin/env bash
 
-# Play stockfish using the UCI_LimitStrength option.
+# Play stockfish using the UCI_LimitStrength option. Pass Swindle's UCI_Elo as the third
+# argument to check the strength limit, the match should be even.
 
 SCRIPT_DIR=$( cd -- "$( dirname -- "${BASH_SOURCE[0]}" )" &> /dev/null && pwd )
 EDIR="$SCRIPT_DIR/competitors"
 
 TIME_CONTROL=$1
 ELO=$2
+SWINDLE_ELO=$3
+
+SWINDLE_OPTIONS=()
+if [ -n "$SWINDLE_ELO" ]; then
+    SWINDLE_OPTIONS=(option.UCI_LimitStrength=true option.UCI_Elo="$SWINDLE_ELO")
+fi
 
 cutechess-cli \
     -rounds 4 -concurrency 2 \
-    -engine cmd="$EDIR/swindle" \
+    -engine cmd="$EDIR/swindle" "${SWINDLE_OPTIONS[@]}" \
     -engine cmd="$EDIR/stockfish15" option.UCI_LimitStrength=true option.UCI_Elo="$ELO" \
     -each tc="$TIME_CONTROL" proto=uci option.Hash=32 \
     -sprt elo0=0 elo1=10 alpha=0.05 beta=0.05 \
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/noahklein/chess/log"
@@ -23,6 +24,12 @@ type Engine interface {
 	Position(fen string, moves []string)
 	Go(info SearchParams) SearchResults
 	Stop()
+	// PonderHit switches a ponder search to a normal timed search.
+	PonderHit()
+	// Solve proves or disproves a forced mate in at most this many moves.
+	Solve(moves int) SearchResults
+	// Trace searches to depth and exports the search tree below a line of moves.
+	Trace(depth int, line []string)
 
 	// IsReady should block until the engine is ready to search.
 	IsReady()
@@ -108,6 +115,7 @@ func handle(engine Engine, input string) error {
 	case "stop":
 		engine.Stop()
 	case "ponderhit":
+		engine.PonderHit()
 	case "debug":
 	case "exit":
 		return errExit
@@ -117,6 +125,33 @@ func handle(engine Engine, input string) error {
 		handle(engine, "uci")
 		handle(engine, "ucinewgame")
 		handle(engine, "position startpos moves e2e4")
+	case "solve":
+		if len(args) == 0 {
+			fmt.Println("Usage: solve <moves>")
+			return nil
+		}
+		moves, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Failed to parse solve command:", err)
+			return nil
+		}
+		go func() {
+			if result := engine.Solve(moves); result.Move != "" {
+				fmt.Printf("bestmove %v\n", result.Move)
+			}
+		}()
+	case "trace":
+		// trace depth <plies> [moves...]
+		if len(args) < 2 || args[0] != "depth" {
+			fmt.Println("Usage: trace depth <plies> [moves...]")
+			return nil
+		}
+		depth, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Failed to parse trace command:", err)
+			return nil
+		}
+		go engine.Trace(depth, args[2:])
 	case "cleartt":
 		engine.ClearTT()
 	case "help":
@@ -137,10 +172,27 @@ Set-up a position:
 To search a position at depth 10:
 	go depth 10
 
+Search a fixed number of nodes, or look for a mate in 3:
+	go nodes 1000000
+	go mate 3
+
+Prove or disprove a mate in 5 with the mate solver:
+	solve 5
+
+Trace a depth 6 search below 1. e4, written to trace.json and trace.dot:
+	trace depth 6 e2e4
+
+Only consider some moves:
+	go depth 10 searchmoves d2d4 g1f3
+
 Infinite search:
 	go infinite
 	stop
 
+Think on the opponent's time, then play their expected move:
+	go ponder wtime 60000 btime 60000
+	ponderhit
+
 Search can be cancelled with the stop command.
 `
 
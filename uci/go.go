@@ -17,8 +17,15 @@ type SearchParams struct {
 	MovesToGo int // Moves till next time control. 0 if sudden death.
 
 	Depth    int
+	Nodes    int // Stop after searching this many nodes.
+	Mate     int // Search for a mate in this many moves.
 	Infinite bool
-	moveTime time.Duration
+	Ponder   bool          // Search until ponderhit or stop.
+	MoveTime time.Duration // Think for exactly this long.
+
+	SearchMoves []string // Only search these root moves, all moves if empty.
+
+	Start time.Time // When the go command arrived, reported times count from here.
 }
 
 type SearchResults struct {
@@ -30,7 +37,13 @@ type SearchResults struct {
 	Nodes    int
 
 	Depth, SelectiveDepth int
-	TableHits             int
+	TableHits             int // Endgame tablebase hits, we don't have tablebases yet.
+
+	// The search failed high or low, the score is only a lower or upper bound.
+	LowerBound, UpperBound bool
+
+	MultiPV int             // Rank of this line, 1 for the best. Not printed if 0.
+	Lines   []SearchResults // MultiPV lines ranked best to worst, including this one.
 }
 
 func (sr SearchResults) Print(start time.Time) string {
@@ -43,15 +56,20 @@ func (sr SearchResults) Print(start time.Time) string {
 
 	add("depth %d", sr.Depth)
 	add("seldepth %d", sr.SelectiveDepth)
+	if sr.MultiPV > 0 {
+		add("multipv %d", sr.MultiPV)
+	}
 	if sr.Mate != 500 {
 		add("score mate %v", sr.Mate)
 	} else {
 		add("score cp %v", sr.Score)
 	}
-	add("hashfull %d", sr.Hashfull)
-	add("time %d", time.Since(start)/time.Millisecond)
-	add("nodes %d", sr.Nodes)
-	add("tbhits %d", sr.TableHits)
+	if sr.LowerBound {
+		add("lowerbound")
+	} else if sr.UpperBound {
+		add("upperbound")
+	}
+	sr.progress(add, start)
 
 	if len(sr.PV) > 0 {
 		add("pv %v", strings.Join(sr.PV, " "))
@@ -60,10 +78,50 @@ func (sr SearchResults) Print(start time.Time) string {
 	return b.String()
 }
 
+// Status reports search progress between PV updates: only the node, hash and tablebase
+// stats of sr are used.
+func (sr SearchResults) Status(start time.Time) string {
+	var b strings.Builder
+	b.WriteString("info ")
+	sr.progress(func(str string, a ...any) {
+		b.WriteString(fmt.Sprintf(str+" ", a...))
+	}, start)
+	return strings.TrimSpace(b.String())
+}
+
+// progress adds the stats shared by PV and status lines.
+func (sr SearchResults) progress(add func(string, ...any), start time.Time) {
+	elapsed := time.Since(start)
+	add("hashfull %d", sr.Hashfull)
+	add("time %d", elapsed/time.Millisecond)
+	add("nodes %d", sr.Nodes)
+	add("nps %d", nps(sr.Nodes, elapsed))
+	add("tbhits %d", sr.TableHits)
+}
+
+// CurrMove reports the root move being searched, numbered from 1 in search order.
+func CurrMove(depth int, move string, number int) string {
+	return fmt.Sprintf("info depth %d currmove %v currmovenumber %d", depth, move, number)
+}
+
+// Nodes per second.
+func nps(nodes int, elapsed time.Duration) int {
+	if elapsed < time.Millisecond {
+		return 0
+	}
+	return int(int64(nodes) * int64(time.Second) / int64(elapsed))
+}
+
 func search(engine Engine, args []string) {
 	params := parseParams(args)
+	params.Start = time.Now()
 	go func() {
 		result := engine.Go(params)
+		if len(result.PV) > 1 && result.PV[0] == result.Move {
+			// Suggest the opponent's reply from the PV for the GUI to ponder on.
+			fmt.Printf("bestmove %v ponder %v\n", result.Move, result.PV[1])
+			return
+		}
 		fmt.Printf("bestmove %v\n", result.Move)
 	}()
 }
@@ -89,19 +147,42 @@ func parseParams(args []string) SearchParams {
 			sp.MovesToGo, _ = strconv.Atoi(args[i+1])
 			i++
 		case "movetime":
-			sp.moveTime = parseMs(args[i+1])
+			sp.MoveTime = parseMs(args[i+1])
 			i++
 		case "depth":
 			sp.Depth, _ = strconv.Atoi(args[i+1])
 			i++
+		case "nodes":
+			sp.Nodes, _ = strconv.Atoi(args[i+1])
+			i++
+		case "mate":
+			sp.Mate, _ = strconv.Atoi(args[i+1])
+			i++
 		case "infinite":
 			sp.Infinite = true
+		case "ponder":
+			sp.Ponder = true
+		case "searchmoves":
+			// Moves continue until the next param.
+			for i+1 < len(args) && !isParam(args[i+1]) {
+				sp.SearchMoves = append(sp.SearchMoves, args[i+1])
+				i++
+			}
 		}
 	}
 
 	return sp
 }
 
+func isParam(s string) bool {
+	switch s {
+	case "wtime", "btime", "winc", "binc", "movestogo", "movetime",
+		"depth", "nodes", "mate", "infinite", "ponder", "searchmoves":
+		return true
+	}
+	return false
+}
+
 // Converts a string number, e.g. "3000", into a duration in milliseconds.
 func parseMs(s string) time.Duration {
 	ms, err := time.ParseDuration(s + "ms")